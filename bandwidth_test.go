@@ -0,0 +1,125 @@
+package garden_integration_tests_test
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/garden"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Bandwidth limits", func() {
+	BeforeEach(func() {
+		if runtime.GOOS == "windows" {
+			Skip("pending for windows")
+		}
+		limits.Bandwidth = garden.BandwidthLimits{
+			RateInBytesPerSecond:      256 * kb,
+			BurstRateInBytesPerSecond: 512 * kb,
+		}
+	})
+
+	JustBeforeEach(func() {
+		bandwidthLimits, err := container.CurrentBandwidthLimits()
+		Expect(err).NotTo(HaveOccurred())
+		if bandwidthLimits == (garden.BandwidthLimits{}) {
+			Skip("Skipping because the server does not support bandwidth shaping")
+		}
+	})
+
+	It("reports the bandwidth limits", func() {
+		bandwidthLimits, err := container.CurrentBandwidthLimits()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bandwidthLimits).To(Equal(limits.Bandwidth))
+	})
+
+	Describe("shaping traffic to a host-side sink", func() {
+		var (
+			transferSize uint64
+			transfer     bandwidthTransfer
+		)
+
+		BeforeEach(func() {
+			transferSize = 3 * mb
+		})
+
+		JustBeforeEach(func() {
+			hostPort, _, err := container.NetIn(0, 8080)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = container.Run(garden.ProcessSpec{
+				User: "root",
+				Path: "sh",
+				Args: []string{"-c", fmt.Sprintf("dd if=/dev/zero bs=1024 count=%d | nc -l -p 8080", transferSize/kb)},
+			}, garden.ProcessIO{
+				Stdout: GinkgoWriter,
+				Stderr: GinkgoWriter,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			transfer = measureBandwidthTransfer(hostPort, transferSize, limits.Bandwidth.BurstRateInBytesPerSecond)
+		})
+
+		It("transfers all of the data", func() {
+			Expect(transfer.bytes).To(Equal(transferSize))
+		})
+
+		It("limits the sustained throughput to the configured rate", func() {
+			sustainedBytes := transfer.bytes - limits.Bandwidth.BurstRateInBytesPerSecond
+			sustainedDuration := transfer.total - transfer.burst
+			rate := float64(sustainedBytes) / sustainedDuration.Seconds()
+
+			Expect(rate).To(BeNumerically("~", limits.Bandwidth.RateInBytesPerSecond, 0.25*float64(limits.Bandwidth.RateInBytesPerSecond)))
+		})
+
+		It("allows a burst above the configured rate", func() {
+			burstAtRate := time.Duration(float64(limits.Bandwidth.BurstRateInBytesPerSecond) / float64(limits.Bandwidth.RateInBytesPerSecond) * float64(time.Second))
+
+			Expect(transfer.burst).To(BeNumerically("<", burstAtRate/2))
+		})
+	})
+})
+
+type bandwidthTransfer struct {
+	bytes uint64
+	// time taken to receive the first burst bytes
+	burst time.Duration
+	// time taken to receive all bytes
+	total time.Duration
+}
+
+func measureBandwidthTransfer(hostPort uint32, size, burst uint64) bandwidthTransfer {
+	gardenHostname := strings.Split(gardenHost, ":")[0]
+
+	var conn net.Conn
+	Eventually(func() error {
+		var err error
+		conn, err = net.DialTimeout("tcp", fmt.Sprintf("%s:%d", gardenHostname, hostPort), 2*time.Second)
+		return err
+	}).Should(Succeed())
+	defer conn.Close()
+	Expect(conn.SetReadDeadline(time.Now().Add(2 * time.Minute))).To(Succeed())
+
+	var transfer bandwidthTransfer
+	buf := make([]byte, 32*kb)
+	start := time.Now()
+	for transfer.bytes < size {
+		n, err := conn.Read(buf)
+		transfer.bytes += uint64(n)
+		if transfer.burst == 0 && transfer.bytes >= burst {
+			transfer.burst = time.Since(start)
+		}
+		if err == io.EOF {
+			break
+		}
+		Expect(err).NotTo(HaveOccurred())
+	}
+	transfer.total = time.Since(start)
+
+	return transfer
+}
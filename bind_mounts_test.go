@@ -0,0 +1,200 @@
+package garden_integration_tests_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/garden"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+// maximusUID is the host uid that root in an unprivileged container maps to
+const maximusUID = "4294967294"
+
+var _ = Describe("Bind mounts", func() {
+	var srcPath string
+
+	BeforeEach(func() {
+		srcPath = ""
+		if runtime.GOOS == "windows" {
+			Skip("pending for windows")
+		}
+		skipIfNotColocated()
+
+		var err error
+		srcPath, err = os.MkdirTemp("", "bind-mount-src")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Chmod(srcPath, 0777)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(srcPath, "host-file"), []byte("hello from the host"), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(srcPath)).To(Succeed())
+	})
+
+	Context("when a host directory is bind mounted read-only", func() {
+		BeforeEach(func() {
+			bindMounts = []garden.BindMount{{
+				SrcPath: srcPath,
+				DstPath: "/home/alice/ro-mount",
+				Mode:    garden.BindMountModeRO,
+				Origin:  garden.BindMountOriginHost,
+			}}
+		})
+
+		It("can read from the mount", func() {
+			stdout := runForStdout(container, garden.ProcessSpec{
+				User: "root",
+				Path: "cat",
+				Args: []string{"/home/alice/ro-mount/host-file"},
+			})
+			Expect(stdout).To(gbytes.Say("hello from the host"))
+		})
+
+		It("cannot write to the mount", func() {
+			exitCode, _, stderr := runProcess(container, garden.ProcessSpec{
+				User: "root",
+				Path: "touch",
+				Args: []string{"/home/alice/ro-mount/container-file"},
+			})
+			Expect(exitCode).NotTo(Equal(0))
+			Expect(stderr).To(gbytes.Say("Read-only file system"))
+			Expect(filepath.Join(srcPath, "container-file")).NotTo(BeAnExistingFile())
+		})
+
+		It("is not visible in other containers", func() {
+			otherContainer, err := gardenClient.Create(garden.ContainerSpec{})
+			Expect(err).NotTo(HaveOccurred())
+			defer func() {
+				Expect(gardenClient.Destroy(otherContainer.Handle())).To(Succeed())
+			}()
+
+			exitCode, _, _ := runProcess(otherContainer, garden.ProcessSpec{
+				User: "root",
+				Path: "test",
+				Args: []string{"-e", "/home/alice/ro-mount/host-file"},
+			})
+			Expect(exitCode).NotTo(Equal(0))
+
+			stdout := runForStdout(otherContainer, garden.ProcessSpec{
+				User: "root",
+				Path: "cat",
+				Args: []string{"/proc/mounts"},
+			})
+			Expect(stdout).NotTo(gbytes.Say("/home/alice/ro-mount"))
+		})
+	})
+
+	Context("when a host directory is bind mounted read-write", func() {
+		BeforeEach(func() {
+			bindMounts = []garden.BindMount{{
+				SrcPath: srcPath,
+				DstPath: "/home/alice/rw-mount",
+				Mode:    garden.BindMountModeRW,
+				Origin:  garden.BindMountOriginHost,
+			}}
+		})
+
+		It("can write to the mount and the host sees the changes", func() {
+			exitCode, _, _ := runProcess(container, garden.ProcessSpec{
+				User: "root",
+				Path: "sh",
+				Args: []string{"-c", "echo hello from the container > /home/alice/rw-mount/container-file"},
+			})
+			Expect(exitCode).To(Equal(0))
+
+			Expect(os.ReadFile(filepath.Join(srcPath, "container-file"))).To(Equal([]byte("hello from the container\n")))
+		})
+
+		It("maps files written by container root to the container's root user on the host", func() {
+			exitCode, _, _ := runProcess(container, garden.ProcessSpec{
+				User: "root",
+				Path: "touch",
+				Args: []string{"/home/alice/rw-mount/container-file"},
+			})
+			Expect(exitCode).To(Equal(0))
+
+			Expect(hostFileOwner(filepath.Join(srcPath, "container-file"))).To(Equal(maximusUID))
+		})
+
+		It("shows files owned by host root as owned by the overflow user", func() {
+			stdout := runForStdout(container, garden.ProcessSpec{
+				User: "root",
+				Path: "stat",
+				Args: []string{"-c", "%u", "/home/alice/rw-mount/host-file"},
+			})
+			Expect(stdout).To(gbytes.Say("^65534\n"))
+		})
+
+		Context("when the container is privileged", func() {
+			BeforeEach(func() {
+				setPrivileged()
+			})
+
+			It("maps files written by container root to host root", func() {
+				exitCode, _, _ := runProcess(container, garden.ProcessSpec{
+					User: "root",
+					Path: "touch",
+					Args: []string{"/home/alice/rw-mount/container-file"},
+				})
+				Expect(exitCode).To(Equal(0))
+
+				Expect(hostFileOwner(filepath.Join(srcPath, "container-file"))).To(Equal("0"))
+			})
+		})
+	})
+
+	Context("when a container directory is bind mounted", func() {
+		BeforeEach(func() {
+			bindMounts = []garden.BindMount{{
+				SrcPath: "/etc",
+				DstPath: "/home/alice/etc-mount",
+				Mode:    garden.BindMountModeRO,
+				Origin:  garden.BindMountOriginContainer,
+			}}
+		})
+
+		It("mounts the directory from the container's filesystem", func() {
+			stdout := runForStdout(container, garden.ProcessSpec{
+				User: "root",
+				Path: "cat",
+				Args: []string{"/home/alice/etc-mount/passwd"},
+			})
+			Expect(stdout).To(gbytes.Say("root:"))
+		})
+	})
+
+	Context("when the source path does not exist", func() {
+		BeforeEach(func() {
+			assertContainerCreate = false
+			handle = fmt.Sprintf("invalid-bind-mount-%d-%d", GinkgoParallelProcess(), time.Now().UnixNano())
+			bindMounts = []garden.BindMount{{
+				SrcPath: filepath.Join(srcPath, "does-not-exist"),
+				DstPath: "/home/alice/missing-mount",
+				Mode:    garden.BindMountModeRO,
+				Origin:  garden.BindMountOriginHost,
+			}}
+		})
+
+		It("fails to create the container", func() {
+			Expect(containerCreateErr).To(HaveOccurred())
+		})
+
+		It("does not leave a half-created container behind", func() {
+			Expect(getContainerHandles()).NotTo(ContainElement(handle))
+		})
+	})
+})
+
+func hostFileOwner(path string) string {
+	output, err := exec.Command("stat", "-c", "%u", path).Output()
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return strings.TrimSpace(string(output))
+}
@@ -1,7 +1,12 @@
 package garden_integration_tests_test
 
 import (
+	"os"
+	"runtime"
+	"sync"
+
 	"code.cloudfoundry.org/garden"
+	sigar "github.com/cloudfoundry/gosigar"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -35,6 +40,188 @@ var _ = Describe("Capacity", func() {
 			return capacity().MaxContainers
 		}).Should(BeNumerically(">", 0))
 	})
+
+	Describe("accuracy", func() {
+		BeforeEach(func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+			skipIfNotColocated()
+		})
+
+		It("reports the memory of the host", func() {
+			mem := sigar.Mem{}
+			Expect(mem.Get()).To(Succeed())
+
+			Expect(capacity().MemoryInBytes).To(BeNumerically("~", mem.Total, mem.Total/100))
+		})
+
+		It("reports the size of the filesystem backing the depot", func() {
+			depotDir := os.Getenv("GDN_DEPOT_DIR")
+			if depotDir == "" {
+				depotDir = "/var/vcap/data/garden/depot"
+			}
+			if _, err := os.Stat(depotDir); err != nil {
+				Skip("Skipping because the depot dir is not accessible: " + err.Error())
+			}
+
+			usage := sigar.FileSystemUsage{}
+			Expect(usage.Get(depotDir)).To(Succeed())
+			totalBytes := usage.Total * 1024
+
+			Expect(capacity().DiskInBytes).To(BeNumerically("~", totalBytes, totalBytes/100))
+		})
+	})
+
+	// MaxContainers is enforced across the whole server, so nothing else may
+	// create containers while we fill it up.
+	Describe("MaxContainers", Serial, func() {
+		var containers []garden.Container
+
+		BeforeEach(func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+			containers = nil
+		})
+
+		JustBeforeEach(func() {
+			maxContainers := int(capacity().MaxContainers)
+			if maxContainers > 1024 {
+				Skip("Skipping because filling up the server would take too long")
+			}
+
+			remaining := maxContainers - len(getContainerHandles())
+			created := make(chan garden.Container, remaining)
+
+			var wg sync.WaitGroup
+			workers := make(chan struct{}, 10)
+			for i := 0; i < remaining; i++ {
+				wg.Add(1)
+				workers <- struct{}{}
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					defer func() { <-workers }()
+
+					c, err := gardenClient.Create(garden.ContainerSpec{})
+					Expect(err).NotTo(HaveOccurred())
+					created <- c
+				}()
+			}
+			wg.Wait()
+			close(created)
+
+			for c := range created {
+				containers = append(containers, c)
+			}
+		})
+
+		AfterEach(func() {
+			for _, c := range containers {
+				Expect(destroyContainer(c)).To(Succeed())
+			}
+		})
+
+		It("can create containers up to the maximum", func() {
+			Expect(len(getContainerHandles())).To(BeEquivalentTo(capacity().MaxContainers))
+		})
+
+		It("fails to create any more containers", func() {
+			_, err := gardenClient.Create(garden.ContainerSpec{})
+			// without a configured max containers the subnet pool is what runs out
+			Expect(err).To(MatchError(MatchRegexp(`max containers reached|insufficient (subnets|IPs) remaining in the pool`)))
+		})
+
+		Context("when a container is destroyed", func() {
+			JustBeforeEach(func() {
+				Expect(containers).NotTo(BeEmpty())
+				Expect(destroyContainer(containers[0])).To(Succeed())
+			})
+
+			It("can create a container again", func() {
+				c, err := gardenClient.Create(garden.ContainerSpec{})
+				Expect(err).NotTo(HaveOccurred())
+				containers = append(containers, c)
+			})
+		})
+	})
+
+	// Schedulable disk is shared by the whole server, so nothing else may
+	// create containers while we measure it.
+	Describe("schedulable disk across many containers", Serial, func() {
+		const (
+			containerCount = 5
+			diskQuota      = 100 * mb
+		)
+
+		var (
+			containers            []garden.Container
+			schedulableDiskBefore uint64
+		)
+
+		BeforeEach(func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+			skipIfWoot("Groot does not support disk size limits yet")
+			containers = nil
+		})
+
+		JustBeforeEach(func() {
+			// the suite container is not ours to count
+			Expect(destroyContainer(container)).To(Succeed())
+			schedulableDiskBefore = capacity().SchedulableDiskInBytes
+
+			for i := 0; i < containerCount; i++ {
+				c, err := gardenClient.Create(garden.ContainerSpec{
+					Image: garden.ImageRef{URI: limitsTestURI},
+					Limits: garden.Limits{Disk: garden.DiskLimits{
+						ByteSoft: diskQuota,
+						ByteHard: diskQuota,
+						Scope:    garden.DiskLimitScopeExclusive,
+					}},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				containers = append(containers, c)
+			}
+		})
+
+		AfterEach(func() {
+			for _, c := range containers {
+				Expect(destroyContainer(c)).To(Succeed())
+			}
+		})
+
+		It("reserves the disk quotas rather than a copy of the image per container", func() {
+			metrics, err := containers[0].Metrics()
+			Expect(err).NotTo(HaveOccurred())
+			imageSize := metrics.DiskStat.TotalBytesUsed - metrics.DiskStat.ExclusiveBytesUsed
+
+			reserved := schedulableDiskBefore - capacity().SchedulableDiskInBytes
+			Expect(reserved).To(BeNumerically(">=", containerCount*diskQuota-containerCount*mb))
+			Expect(reserved).To(BeNumerically("<=", containerCount*diskQuota+imageSize+containerCount*mb))
+		})
+
+		It("starts every container with almost no exclusive disk usage", func() {
+			for _, c := range containers {
+				metrics, err := c.Metrics()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(metrics.DiskStat.ExclusiveBytesUsed).To(BeNumerically("<", mb), c.Handle())
+			}
+		})
+
+		It("restores the schedulable disk when the containers are destroyed", func() {
+			for _, c := range containers {
+				Expect(destroyContainer(c)).To(Succeed())
+			}
+			containers = nil
+
+			Eventually(func() uint64 {
+				return capacity().SchedulableDiskInBytes
+			}).Should(Equal(schedulableDiskBefore))
+		})
+	})
 })
 
 func capacity() garden.Capacity {
@@ -1,8 +1,10 @@
 package garden_integration_tests_test
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
@@ -35,6 +37,7 @@ var _ = Describe("CPU Throttling", func() {
 		var err error
 		containerPort, _, err = container.NetIn(0, 8080)
 		Expect(err).NotTo(HaveOccurred())
+		streamInProbe(container)
 		startSpinnerApp(container, containerPort)
 
 		badContainer, err = gardenClient.Create(garden.ContainerSpec{
@@ -47,18 +50,68 @@ var _ = Describe("CPU Throttling", func() {
 
 		badContainerPort, _, err = badContainer.NetIn(0, 8080)
 		Expect(err).NotTo(HaveOccurred())
+		streamInProbe(badContainer)
+		streamInWorkload(badContainer)
 		startSpinnerApp(badContainer, badContainerPort)
+		// the spinner can be stopped, so watch an idle process to tell where the container is
+		startIdleApp(badContainer)
 	})
 
 	AfterEach(func() {
 		Expect(destroyContainer(badContainer)).To(Succeed())
 	})
 
+	It("reports CPUEntitlement at a constant rate across punishment transitions", func() {
+		rateBeforePunishment := entitlementRate(badContainer)
+
+		spinToPunish(badContainer, badContainerPort)
+		rateWhilePunished := entitlementRate(badContainer)
+
+		stopSpinnerApp(badContainer)
+		Eventually(punished(badContainer, idleApp), "2m", "1s").Should(BeFalse())
+		rateAfterRelease := entitlementRate(badContainer)
+
+		Expect(rateWhilePunished).To(BeNumerically("~", rateBeforePunishment, rateBeforePunishment/10))
+		Expect(rateAfterRelease).To(BeNumerically("~", rateBeforePunishment, rateBeforePunishment/10))
+	})
+
 	Context("CPU-intensive application is punished to the bad cgroup (because it is way over its entitlement)", func() {
 		JustBeforeEach(func() {
 			spinToPunish(badContainer, badContainerPort)
 		})
 
+		Context("and it stays under its entitlement afterwards", func() {
+			JustBeforeEach(func() {
+				stopSpinnerApp(badContainer)
+			})
+
+			It("is released back to the good cgroup", func() {
+				Consistently(currentUsage(badContainer), "5s").Should(BeNumerically("<", 1))
+				Eventually(punished(badContainer, idleApp), "2m", "1s").Should(BeFalse())
+				Consistently(punished(badContainer, idleApp), "10s", "1s").Should(BeFalse())
+			})
+		})
+
+		Context("and a pea is run in the punished container", func() {
+			BeforeEach(func() {
+				skipIfShed()
+			})
+
+			JustBeforeEach(func() {
+				// keep the container over its entitlement while the pea runs
+				startWorkload(badContainer, garden.ProcessSpec{
+					User:  "root",
+					Image: garden.ImageRef{URI: gardenRootfs},
+				}, "burn-user")
+			})
+
+			It("places the pea in the container's bad cgroup", func() {
+				Eventually(func() (string, error) {
+					return cpuCgroupOf(badContainer, "workload")
+				}, "60s").Should(ContainSubstring(filepath.Join(cgroups.BadCgroupName, badContainer.Handle())))
+			})
+		})
+
 		Context("and another application wants to spike", func() {
 			var beforeUsage uint64
 			JustBeforeEach(func() {
@@ -82,8 +135,64 @@ var _ = Describe("CPU Throttling", func() {
 			})
 		})
 	})
+
+	Context("several CPU-intensive applications are punished to the bad cgroup", func() {
+		var otherBadContainer garden.Container
+
+		BeforeEach(func() {
+			otherBadContainer = nil
+		})
+
+		JustBeforeEach(func() {
+			var err error
+			otherBadContainer, err = gardenClient.Create(garden.ContainerSpec{
+				Image:  imageRef,
+				Limits: garden.Limits{CPU: garden.CPULimits{Weight: 100}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			streamInWorkload(otherBadContainer)
+
+			for _, c := range []garden.Container{badContainer, otherBadContainer} {
+				// pinned to the same CPU so that they compete for it
+				startWorkload(c, garden.ProcessSpec{User: "root"}, "burn-user", "0")
+			}
+			for _, c := range []garden.Container{badContainer, otherBadContainer} {
+				Eventually(punished(c, "workload"), "60s").Should(BeTrue())
+			}
+		})
+
+		AfterEach(func() {
+			if otherBadContainer != nil {
+				Expect(destroyContainer(otherBadContainer)).To(Succeed())
+			}
+		})
+
+		It("shares the bad cgroup fairly between them", func() {
+			badInitialUsage, _, err := getCPUUsageAndEntitlement(badContainer)
+			Expect(err).NotTo(HaveOccurred())
+			otherInitialUsage, _, err := getCPUUsageAndEntitlement(otherBadContainer)
+			Expect(err).NotTo(HaveOccurred())
+
+			time.Sleep(10 * time.Second)
+
+			badFinalUsage, _, err := getCPUUsageAndEntitlement(badContainer)
+			Expect(err).NotTo(HaveOccurred())
+			otherFinalUsage, _, err := getCPUUsageAndEntitlement(otherBadContainer)
+			Expect(err).NotTo(HaveOccurred())
+
+			ratio := float64(badFinalUsage-badInitialUsage) / float64(otherFinalUsage-otherInitialUsage)
+			Expect(ratio).To(BeNumerically("~", 1, 0.25))
+		})
+	})
 })
 
+const (
+	// spinnerApp is the name of the long running CPU spinner in the test rootfs
+	spinnerApp = "throttled-or-not"
+	// idleApp is the name of a long running process that uses no CPU
+	idleApp = "sleep"
+)
+
 func skipIfCpuThrottlingNotEnabled() {
 	if os.Getenv("CPU_THROTTLING_ENABLED") == "true" {
 		return
@@ -108,24 +217,38 @@ func spin(container garden.Container, containerPort uint32) error {
 
 func spinToPunish(container garden.Container, port uint32) {
 	ExpectWithOffset(1, spin(container, port)).To(Succeed())
-	EventuallyWithOffset(1, punished(container, port), "60s").Should(BeTrue())
+	EventuallyWithOffset(1, punished(container, spinnerApp), "60s").Should(BeTrue())
 }
 
 func startSpinnerApp(container garden.Container, containerPort uint32) {
-	_, err := container.Run(garden.ProcessSpec{Path: "/bin/throttled-or-not"}, garden.ProcessIO{})
+	_, err := container.Run(garden.ProcessSpec{Path: "/bin/" + spinnerApp}, garden.ProcessIO{})
 	Expect(err).NotTo(HaveOccurred())
 
 	Eventually(func() (string, error) {
 		return httpGet(fmt.Sprintf("http://%s:%d/ping", externalIP(container), containerPort))
 	}).Should(Equal("pong"))
-	ensureInitialSpikeIsOver(container, containerPort)
+	ensureInitialSpikeIsOver(container)
+}
+
+func stopSpinnerApp(container garden.Container) {
+	exitCode, _, _ := runProcess(container, garden.ProcessSpec{
+		User: "root",
+		Path: "killall",
+		Args: []string{spinnerApp},
+	})
+	ExpectWithOffset(1, exitCode).To(Equal(0))
 }
 
-func ensureInitialSpikeIsOver(container garden.Container, port uint32) {
+func startIdleApp(container garden.Container) {
+	_, err := container.Run(garden.ProcessSpec{Path: idleApp, Args: []string{"3600"}}, garden.ProcessIO{})
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+}
+
+func ensureInitialSpikeIsOver(container garden.Container) {
 	// Wait for the usage to drop below 0.01 (i.e. the container is done initialisizing and is idle)
 	// and eventually get into the good cgroup
 	Eventually(currentUsage(container), "1m", "1s").Should(BeNumerically("<", 0.01))
-	Eventually(punished(container, port), "1m", "1s").Should(BeFalse())
+	Eventually(punished(container, spinnerApp), "1m", "1s").Should(BeFalse())
 }
 
 func getCPUUsageAndEntitlement(container garden.Container) (uint64, uint64, error) {
@@ -160,8 +283,45 @@ func currentUsage(container garden.Container) func() (float64, error) {
 	}
 }
 
-func isPunished(container garden.Container, containerPort uint32) (bool, error) {
-	cgroup, err := httpGet(fmt.Sprintf("http://%s:%d/cpucgroup", externalIP(container), containerPort))
+// entitlementRate measures how fast the container's CPU entitlement grows
+func entitlementRate(container garden.Container) float64 {
+	_, initialEntitlement, err := getCPUUsageAndEntitlement(container)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	start := time.Now()
+
+	time.Sleep(3 * time.Second)
+
+	_, finalEntitlement, err := getCPUUsageAndEntitlement(container)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	ExpectWithOffset(1, finalEntitlement).To(BeNumerically(">=", initialEntitlement))
+
+	return float64(finalEntitlement-initialEntitlement) / float64(time.Since(start))
+}
+
+// cpuCgroupOf asks the probe plugin for the CPU cgroup of the named process
+func cpuCgroupOf(container garden.Container, processName string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	process, err := container.Run(
+		garden.ProcessSpec{User: "root", Path: path.Join(probeDir, "probe"), Args: []string{"cgroup", processName}},
+		garden.ProcessIO{Stdout: &stdout, Stderr: &stderr},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	exitCode, err := process.Wait()
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		return "", fmt.Errorf("probe exited with %d: %s", exitCode, stderr.String())
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+func isPunished(container garden.Container, processName string) (bool, error) {
+	cgroup, err := cpuCgroupOf(container, processName)
 	if err != nil {
 		return false, err
 	}
@@ -169,9 +329,9 @@ func isPunished(container garden.Container, containerPort uint32) (bool, error)
 	return strings.HasSuffix(cgroup, filepath.Join(cgroups.BadCgroupName, container.Handle())), nil
 }
 
-func punished(container garden.Container, containerPort uint32) func() (bool, error) {
+func punished(container garden.Container, processName string) func() (bool, error) {
 	return func() (bool, error) {
-		return isPunished(container, containerPort)
+		return isPunished(container, processName)
 	}
 }
 
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"runtime"
 	"strconv"
 	"strings"
@@ -24,6 +25,12 @@ import (
 	"github.com/onsi/gomega/gexec"
 )
 
+const (
+	probeDir    = "/opt/probe"
+	workloadDir = "/opt/workload"
+	spoofDir    = "/opt/spoof"
+)
+
 var (
 	gardenHost            string
 	gardenPort            string
@@ -42,8 +49,14 @@ var (
 	properties          garden.Properties
 	limits              garden.Limits
 	env                 []string
+	netIn               []garden.NetIn
+	netOut              []garden.NetOutRule
+	bindMounts          []garden.BindMount
 
-	consumeBin string
+	consumeBin  string
+	probeBin    string
+	workloadBin string
+	spoofBin    string
 
 	limitsTestURI                string
 	limitsTestContainerImageSize uint64 // Obtained by summing the values in <groot-image-store>\layers\<layer-id>\size
@@ -60,10 +73,22 @@ var _ = SynchronizedBeforeSuite(func() []byte {
 	ExpectWithOffset(1, exists).To(BeTrue(), "Set GARDEN_TEST_ROOTFS Env variable")
 
 	binary := ""
+	probe := ""
+	workload := ""
+	spoof := ""
 	if runtime.GOOS == "windows" {
 		var err error
 		binary, err = gexec.Build("code.cloudfoundry.org/garden-integration-tests/plugins/consume-mem")
 		Expect(err).ToNot(HaveOccurred())
+	} else {
+		var err error
+		// statically linked so that it runs in any rootfs
+		probe, err = gexec.BuildWithEnvironment("code.cloudfoundry.org/garden-integration-tests/plugins/probe", []string{"CGO_ENABLED=0", "GOOS=linux"})
+		Expect(err).ToNot(HaveOccurred())
+		workload, err = gexec.BuildWithEnvironment("code.cloudfoundry.org/garden-integration-tests/plugins/workload", []string{"CGO_ENABLED=0", "GOOS=linux"})
+		Expect(err).ToNot(HaveOccurred())
+		spoof, err = gexec.BuildWithEnvironment("code.cloudfoundry.org/garden-integration-tests/plugins/spoof", []string{"CGO_ENABLED=0", "GOOS=linux"})
+		Expect(err).ToNot(HaveOccurred())
 	}
 
 	limitsURI, exists := os.LookupEnv("LIMITS_TEST_URI")
@@ -73,6 +98,9 @@ var _ = SynchronizedBeforeSuite(func() []byte {
 	testData["gardenHost"] = host
 	testData["gardenRootfs"] = rootfs
 	testData["consumeBin"] = binary
+	testData["probeBin"] = probe
+	testData["workloadBin"] = workload
+	testData["spoofBin"] = spoof
 	testData["limitsTestUri"] = limitsURI
 
 	json, err := json.Marshal(testData)
@@ -86,6 +114,9 @@ var _ = SynchronizedBeforeSuite(func() []byte {
 	gardenHost = testData["gardenHost"].(string)
 	gardenRootfs = testData["gardenRootfs"].(string)
 	consumeBin = testData["consumeBin"].(string)
+	probeBin = testData["probeBin"].(string)
+	workloadBin = testData["workloadBin"].(string)
+	spoofBin = testData["spoofBin"].(string)
 	limitsTestURI = testData["limitsTestUri"].(string)
 	limitsTestContainerImageSize = 4562899158 //Used only in windows tests
 })
@@ -108,6 +139,9 @@ func TestGardenIntegrationTests(t *testing.T) {
 		properties = garden.Properties{}
 		limits = garden.Limits{}
 		env = []string{}
+		netIn = nil
+		netOut = nil
+		bindMounts = nil
 		gardenPort = os.Getenv("GDN_BIND_PORT")
 		if gardenPort == "" {
 			gardenPort = "7777"
@@ -129,6 +163,9 @@ func TestGardenIntegrationTests(t *testing.T) {
 			Env:        env,
 			Limits:     limits,
 			Network:    networkSpec,
+			NetIn:      netIn,
+			NetOut:     netOut,
+			BindMounts: bindMounts,
 		})
 
 		if container != nil {
@@ -246,6 +283,16 @@ func shed() bool {
 	return os.Getenv("SHED") != ""
 }
 
+func skipIfNotColocated() {
+	if !colocated() {
+		Skip("Skipping because the test runner is not colocated with the garden server")
+	}
+}
+
+func colocated() bool {
+	return os.Getenv("GDN_COLOCATED") == "true"
+}
+
 func skipIfContainerdForProcesses() {
 	if isContainerdForProcesses() {
 		Skip("Skipping because containerd support for processes is enabled")
@@ -324,6 +371,84 @@ func getContainerUsage(handle string) uint64 {
 	return uintUsage
 }
 
+// streamInProbe copies the probe plugin into the container's rootfs at probeDir
+func streamInProbe(container garden.Container) {
+	contents, err := os.ReadFile(probeBin)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+	var archive bytes.Buffer
+	writeTar(&archive, []archiveFile{{Name: "./probe", Body: contents, Mode: 0755}})
+
+	ExpectWithOffset(1, container.StreamIn(garden.StreamInSpec{
+		Path:      probeDir,
+		User:      "root",
+		TarStream: &archive,
+	})).To(Succeed())
+}
+
+// runProbe runs the probe plugin with the given command. Peas get the probe
+// bind mounted from the sandbox, so streamInProbe must have been called first.
+func runProbe(container garden.Container, spec garden.ProcessSpec, command string) string {
+	spec.Path = path.Join(probeDir, "probe")
+	spec.Args = []string{command}
+	if spec.Image.URI != "" {
+		spec.BindMounts = append(spec.BindMounts, garden.BindMount{
+			SrcPath: probeDir,
+			DstPath: probeDir,
+			Mode:    garden.BindMountModeRO,
+			Origin:  garden.BindMountOriginContainer,
+		})
+	}
+
+	exitCode, stdout, _ := runProcess(container, spec)
+	ExpectWithOffset(1, exitCode).To(Equal(0))
+	return strings.TrimSpace(string(stdout.Contents()))
+}
+
+// streamInWorkload copies the workload plugin into the container's rootfs at workloadDir
+func streamInWorkload(container garden.Container) {
+	contents, err := os.ReadFile(workloadBin)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+	var archive bytes.Buffer
+	writeTar(&archive, []archiveFile{{Name: "./workload", Body: contents, Mode: 0755}})
+
+	ExpectWithOffset(1, container.StreamIn(garden.StreamInSpec{
+		Path:      workloadDir,
+		User:      "root",
+		TarStream: &archive,
+	})).To(Succeed())
+}
+
+// workloadProcessSpec runs the workload plugin with spec. Peas get the workload
+// bind mounted from the sandbox, so streamInWorkload must have been called first.
+func workloadProcessSpec(spec garden.ProcessSpec, args ...string) garden.ProcessSpec {
+	spec.Path = path.Join(workloadDir, "workload")
+	spec.Args = args
+	if spec.Image.URI != "" {
+		spec.BindMounts = append(spec.BindMounts, garden.BindMount{
+			SrcPath: workloadDir,
+			DstPath: workloadDir,
+			Mode:    garden.BindMountModeRO,
+			Origin:  garden.BindMountOriginContainer,
+		})
+	}
+	return spec
+}
+
+// startWorkload runs the workload plugin and waits until it has consumed the
+// requested resources. The workload holds on to them until it is signalled.
+func startWorkload(container garden.Container, spec garden.ProcessSpec, args ...string) garden.Process {
+	stdout := gbytes.NewBuffer()
+	process, err := container.Run(workloadProcessSpec(spec, args...), garden.ProcessIO{
+		Stdout: io.MultiWriter(stdout, GinkgoWriter),
+		Stderr: GinkgoWriter,
+	})
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	EventuallyWithOffset(1, stdout, "30s").Should(gbytes.Say("ready"))
+	return process
+}
+
 func httpGet(url string) (string, error) {
 	resp, err := http.Get(url)
 	if err != nil {
@@ -13,16 +13,17 @@ require (
 	code.cloudfoundry.org/archiver v0.84.0
 	code.cloudfoundry.org/garden v0.0.0-20260814181737-66902029982f
 	code.cloudfoundry.org/guardian v0.0.0-20260818152501-8ea7fb3095cb
+	code.cloudfoundry.org/lager/v3 v3.82.0
 	github.com/cloudfoundry/gosigar v1.3.126
 	github.com/nu7hatch/gouuid v0.0.0-20131221200532-179d4d0c4d8d
 	github.com/onsi/ginkgo/v2 v2.32.1
 	github.com/onsi/gomega v1.42.1
 	github.com/wavefronthq/wavefront-sdk-go v0.15.0
+	golang.org/x/sys v0.47.0
 )
 
 require (
 	code.cloudfoundry.org/commandrunner v0.73.0 // indirect
-	code.cloudfoundry.org/lager/v3 v3.82.0 // indirect
 	github.com/Masterminds/semver/v3 v3.5.0 // indirect
 	github.com/bmizerany/pat v0.0.0-20210406213842-e4b6760bdd6f // indirect
 	github.com/caio/go-tdigest/v4 v4.1.0 // indirect
@@ -52,7 +53,6 @@ require (
 	golang.org/x/mod v0.40.0 // indirect
 	golang.org/x/net v0.58.0 // indirect
 	golang.org/x/sync v0.22.0 // indirect
-	golang.org/x/sys v0.47.0 // indirect
 	golang.org/x/text v0.41.0 // indirect
 	golang.org/x/tools v0.49.0 // indirect
 	google.golang.org/protobuf v1.36.12 // indirect
@@ -0,0 +1,133 @@
+package garden_integration_tests_test
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+
+	"code.cloudfoundry.org/garden"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+const googleDNSIPv6Secondary = "2001:4860:4860::8844"
+
+var _ = Describe("IPv6 networking", func() {
+	var sibling garden.Container
+
+	BeforeEach(func() {
+		if runtime.GOOS == "windows" {
+			Skip("pending for windows")
+		}
+		networkSpec = fmt.Sprintf("192.168.%d.0/24", 60+GinkgoParallelProcess())
+		sibling = nil
+	})
+
+	JustBeforeEach(func() {
+		skipIfIPv6NotEnabled(container)
+
+		var err error
+		sibling, err = gardenClient.Create(garden.ContainerSpec{Network: networkSpec})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		if sibling != nil {
+			Expect(destroyContainer(sibling)).To(Succeed())
+		}
+	})
+
+	It("configures the reported IPv6 address on the container interface", func() {
+		ipv6 := containerIPv6(container)
+		Expect(net.ParseIP(ipv6).To4()).To(BeNil())
+
+		stdout := runForStdout(container, garden.ProcessSpec{
+			User: "root",
+			Path: "ip",
+			Args: []string{"-6", "addr", "show"},
+		})
+		Expect(stdout).To(gbytes.Say(fmt.Sprintf(`inet6 %s/`, ipv6)))
+	})
+
+	It("can reach its own IPv6 address", func() {
+		Expect(pingSucceeds(container, "ping6", containerIPv6(container))).To(BeTrue())
+	})
+
+	It("can reach a fixture in another container over IPv6", func() {
+		listenInContainer(sibling, 8080, "hallo")
+
+		Eventually(func() string {
+			return receiveFromContainerIP(container, containerIPv6(sibling), 8080)
+		}).Should(ContainSubstring("hallo"))
+	})
+
+	Describe("NetOut rules with IPv6 ranges", func() {
+		JustBeforeEach(func() {
+			skipIfNetworksNotDenied("ping6", googleDNSIPv6)
+		})
+
+		It("only permits ICMPv6 to the allowed range", func() {
+			Expect(container.NetOut(garden.NetOutRule{
+				Protocol: garden.ProtocolICMPv6,
+				Networks: []garden.IPRange{garden.IPRangeFromIP(net.ParseIP(googleDNSIPv6))},
+				ICMPs:    &garden.ICMPControl{Type: icmpv6EchoRequest},
+			})).To(Succeed())
+
+			Eventually(func() bool {
+				return pingSucceeds(container, "ping6", googleDNSIPv6)
+			}).Should(BeTrue())
+			Expect(pingSucceeds(container, "ping6", googleDNSIPv6Secondary)).To(BeFalse())
+		})
+
+		It("only permits TCP to the allowed range", func() {
+			_, ipNet, err := net.ParseCIDR(googleDNSIPv6 + "/128")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(container.NetOut(garden.NetOutRule{
+				Protocol: garden.ProtocolTCP,
+				Networks: []garden.IPRange{garden.IPRangeFromIPNet(ipNet)},
+				Ports:    []garden.PortRange{garden.PortRangeFromPort(53)},
+			})).To(Succeed())
+
+			Eventually(func() error {
+				return checkConnection(container, googleDNSIPv6, 53)
+			}).Should(Succeed())
+			Expect(checkConnection(container, googleDNSIPv6Secondary, 53)).NotTo(Succeed())
+		})
+	})
+
+	Context("when the container is destroyed", func() {
+		It("releases its IPv6 address", func() {
+			ipv6 := containerIPv6(container)
+			Eventually(func() bool {
+				return pingSucceeds(sibling, "ping6", ipv6)
+			}).Should(BeTrue())
+
+			ip := containerIP(container)
+			Expect(gardenClient.Destroy(container.Handle())).To(Succeed())
+
+			Eventually(func() bool {
+				return pingSucceeds(sibling, "ping6", ipv6)
+			}).Should(BeFalse())
+
+			// a leaked address could not be handed out to a replacement on the same IP
+			replacement, err := gardenClient.Create(garden.ContainerSpec{Network: ip + "/24"})
+			Expect(err).NotTo(HaveOccurred())
+			defer func() {
+				Expect(destroyContainer(replacement)).To(Succeed())
+			}()
+
+			Expect(containerIPv6(replacement)).To(Equal(ipv6))
+			Eventually(func() bool {
+				return pingSucceeds(sibling, "ping6", ipv6)
+			}).Should(BeTrue())
+		})
+	})
+})
+
+func containerIPv6(container garden.Container) string {
+	info, err := container.Info()
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return info.ContainerIPv6
+}
@@ -720,55 +720,6 @@ var _ = Describe("Lifecycle", func() {
 			}
 		})
 
-		It("streams input to the process's stdin", func() {
-			if runtime.GOOS == "windows" {
-				stdout := gbytes.NewBuffer()
-				pio := garden.ProcessIO{
-					Stdin:  bytes.NewBufferString("hello\nworld\n"),
-					Stdout: stdout,
-				}
-
-				process, err := container.Run(garden.ProcessSpec{
-					User: regularUser,
-					Path: "findstr",
-					Args: []string{".*"},
-				}, pio)
-				Expect(err).ToNot(HaveOccurred())
-
-				Eventually(stdout).Should(gbytes.Say("hello\nworld\n"))
-
-				exitCode, err := process.Wait()
-				Expect(err).NotTo(HaveOccurred())
-				Expect(exitCode).To(Equal(0))
-			} else {
-				stdinR, stdinW, err := os.Pipe()
-				Expect(err).NotTo(HaveOccurred())
-				defer stdinR.Close()
-
-				stdout := gbytes.NewBuffer()
-				pio := garden.ProcessIO{
-					Stdin:  stdinR,
-					Stdout: stdout,
-				}
-
-				process, err := container.Run(garden.ProcessSpec{
-					User: "alice",
-					Path: "sh",
-					Args: []string{"-c", "cat <&0"},
-				}, pio)
-				Expect(err).ToNot(HaveOccurred())
-
-				fmt.Fprintln(stdinW, "hello\nworld")
-				Eventually(stdout).Should(gbytes.Say("hello\nworld"))
-
-				stdinW.Close()
-
-				exitCode, err := process.Wait()
-				Expect(err).NotTo(HaveOccurred())
-				Expect(exitCode).To(Equal(0))
-			}
-		})
-
 		It("forwards the exit status even if stdin is still being written", func() {
 			// this covers the case of intermediaries shuffling i/o around (e.g. wsh)
 			// receiving SIGPIPE on write() due to the backing process exiting without
@@ -806,202 +757,7 @@ var _ = Describe("Lifecycle", func() {
 			}
 		})
 
-		Context("with a tty", func() {
-			It("executes the process with a raw tty with the default window size", func() {
-				if runtime.GOOS == "windows" {
-					Skip("pending for windows")
-				}
-				stdout := gbytes.NewBuffer()
-				_, err := container.Run(garden.ProcessSpec{
-					User: regularUser,
-					Path: shell,
-					Args: []string{
-						"-c",
-						`
-						# The mechanism that is used to set TTY size (ioctl) is
-						# asynchronous. Hence, stty does not return the correct result
-						# right after the process is launched.
-						while true; do
-							stty -a
-							/bin/sleep 1
-						done
-					`,
-					},
-					TTY: new(garden.TTYSpec),
-				}, garden.ProcessIO{
-					Stdout: stdout,
-				})
-				Expect(err).ToNot(HaveOccurred())
-
-				Eventually(stdout, "3s").Should(gbytes.Say("rows 24; columns 80;"))
-			})
-
-			It("executes the process with a raw tty with the given window size", func() {
-				if runtime.GOOS == "windows" {
-					Skip("pending for windows")
-				}
-				stdout := gbytes.NewBuffer()
-				_, err := container.Run(garden.ProcessSpec{
-					User: regularUser,
-					Path: shell,
-					Args: []string{
-						"-c",
-						`
-						# The mechanism that is used to set TTY size (ioctl) is
-						# asynchronous. Hence, stty does not return the correct result
-						# right after the process is launched.
-						while true; do
-							stty -a
-							/bin/sleep 1
-						done
-					`,
-					},
-					TTY: &garden.TTYSpec{
-						WindowSize: &garden.WindowSize{
-							Columns: 123,
-							Rows:    456,
-						},
-					},
-				}, garden.ProcessIO{
-					Stdout: stdout,
-				})
-				Expect(err).ToNot(HaveOccurred())
-
-				Eventually(stdout, "3s").Should(gbytes.Say("rows 456; columns 123;"))
-			})
-
-			It("executes the process with a raw tty and with onlcr to preserve formatting (\r\n, not just \n)", func() {
-				if runtime.GOOS == "windows" {
-					Skip("pending for windows")
-				}
-				stdout := gbytes.NewBuffer()
-				_, err := container.Run(garden.ProcessSpec{
-					Path: shell,
-					Args: []string{
-						"-c",
-						`
-						while true; do
-							echo -e "new\nline"
-							/bin/sleep 1
-					  done
-					`,
-					},
-					TTY: &garden.TTYSpec{},
-				}, garden.ProcessIO{
-					Stdout: stdout,
-				})
-				Expect(err).ToNot(HaveOccurred())
-
-				Eventually(stdout).Should(gbytes.Say("new\r\nline"))
-			})
-
-			It("can have its terminal resized", func() {
-				if runtime.GOOS == "windows" {
-					Skip("pending for windows")
-				}
-				skipIfContainerdForProcesses()
-				stdout := gbytes.NewBuffer()
-
-				inR, inW := io.Pipe()
-
-				process, err := container.Run(garden.ProcessSpec{
-					User: "alice",
-					Path: "sh",
-					Args: []string{
-						"-c",
-						`
-						trap "stty -a" SIGWINCH
-
-						# continuously read so that the trap can keep firing
-						while true; do
-							echo waiting
-							if read; then
-								exit 0
-							fi
-						done
-					`,
-					},
-					TTY: &garden.TTYSpec{
-						WindowSize: &garden.WindowSize{
-							Columns: 13,
-							Rows:    46,
-						},
-					},
-				}, garden.ProcessIO{
-					Stdin:  inR,
-					Stdout: stdout,
-				})
-				Expect(err).ToNot(HaveOccurred())
-
-				Eventually(stdout).Should(gbytes.Say("waiting"))
-
-				err = process.SetTTY(garden.TTYSpec{
-					WindowSize: &garden.WindowSize{
-						Columns: 123,
-						Rows:    456,
-					},
-				})
-				Expect(err).ToNot(HaveOccurred())
-
-				Eventually(stdout).Should(gbytes.Say("rows 456; columns 123;"))
-
-				_, err = fmt.Fprintf(inW, "ok\n")
-				Expect(err).ToNot(HaveOccurred())
-
-				Expect(process.Wait()).To(Equal(0))
-			})
-
-			It("all attached clients should get stdout and stderr", func() {
-				if runtime.GOOS == "windows" {
-					Skip("pending for windows")
-				}
-				skipIfContainerdForProcesses()
-
-				var runStdout, attachStdout bytes.Buffer
-				stdinR, stdinW := io.Pipe()
-				defer stdinW.Close()
-
-				process, err := container.Run(garden.ProcessSpec{
-					Path: shell,
-					Args: []string{"-c", `
-read -s
-
-for i in $(seq 1 5); do
-	echo $i
-	echo $i >&2
-done
-					`},
-					TTY: new(garden.TTYSpec),
-				}, garden.ProcessIO{
-					Stdin:  stdinR,
-					Stdout: io.MultiWriter(&runStdout, GinkgoWriter),
-					Stderr: GinkgoWriter,
-				})
-				Expect(err).ToNot(HaveOccurred())
-
-				attachedProcess, err := container.Attach(process.ID(), garden.ProcessIO{
-					Stdout: io.MultiWriter(&attachStdout, GinkgoWriter),
-					Stderr: GinkgoWriter,
-				})
-				Expect(err).NotTo(HaveOccurred())
-
-				_, err = fmt.Fprintf(stdinW, "ok\n")
-				Expect(err).ToNot(HaveOccurred())
-
-				exitCode, err := process.Wait()
-				Expect(err).NotTo(HaveOccurred())
-				Expect(exitCode).To(Equal(0))
-
-				// Looks redundant, but avoids race as we have 2 representations of the process
-				exitCode, err = attachedProcess.Wait()
-				Expect(err).NotTo(HaveOccurred())
-				Expect(exitCode).To(Equal(0))
-
-				expected := `(ok\r\n)?1\r\n1\r\n2\r\n2\r\n3\r\n3\r\n4\r\n4\r\n5\r\n5\r\n`
-				Expect(runStdout.String()).To(MatchRegexp(expected), "run buffer:")
-				Expect(attachStdout.String()).To(MatchRegexp(expected), "attach buffer:")
-			})
-		})
+		itBehavesLikeAnInteractiveProcess(func() garden.ImageRef { return garden.ImageRef{} })
 
 		Context("with a working directory", func() {
 			It("executes with the working directory as the dir", func() {
@@ -1586,3 +1342,273 @@ done
 		})
 	})
 })
+
+// itBehavesLikeAnInteractiveProcess covers stdin, TTY and Attach for processes
+// run with the given image, so that containers and peas are held to the same standard.
+func itBehavesLikeAnInteractiveProcess(image func() garden.ImageRef) {
+	const regularUser = "alice"
+	shell := "sh"
+	// peas get a fresh image in which only root is known to exist
+	user := func() string {
+		if image().URI != "" {
+			return "root"
+		}
+		return regularUser
+	}
+	if runtime.GOOS == "windows" {
+		shell = "cmd.exe"
+	}
+
+	It("streams input to the process's stdin", func() {
+		if runtime.GOOS == "windows" {
+			stdout := gbytes.NewBuffer()
+			pio := garden.ProcessIO{
+				Stdin:  bytes.NewBufferString("hello\nworld\n"),
+				Stdout: stdout,
+			}
+
+			process, err := container.Run(garden.ProcessSpec{
+				Image: image(),
+				User:  user(),
+				Path:  "findstr",
+				Args:  []string{".*"},
+			}, pio)
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(stdout).Should(gbytes.Say("hello\nworld\n"))
+
+			exitCode, err := process.Wait()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exitCode).To(Equal(0))
+		} else {
+			stdinR, stdinW, err := os.Pipe()
+			Expect(err).NotTo(HaveOccurred())
+			defer stdinR.Close()
+
+			stdout := gbytes.NewBuffer()
+			pio := garden.ProcessIO{
+				Stdin:  stdinR,
+				Stdout: stdout,
+			}
+
+			process, err := container.Run(garden.ProcessSpec{
+				Image: image(),
+				User:  user(),
+				Path:  "sh",
+				Args:  []string{"-c", "cat <&0"},
+			}, pio)
+			Expect(err).ToNot(HaveOccurred())
+
+			fmt.Fprintln(stdinW, "hello\nworld")
+			Eventually(stdout).Should(gbytes.Say("hello\nworld"))
+
+			stdinW.Close()
+
+			exitCode, err := process.Wait()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exitCode).To(Equal(0))
+		}
+	})
+
+	Context("with a tty", func() {
+		It("executes the process with a raw tty with the default window size", func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+			stdout := gbytes.NewBuffer()
+			_, err := container.Run(garden.ProcessSpec{
+				Image: image(),
+				User:  user(),
+				Path:  shell,
+				Args: []string{
+					"-c",
+					`
+					# The mechanism that is used to set TTY size (ioctl) is
+					# asynchronous. Hence, stty does not return the correct result
+					# right after the process is launched.
+					while true; do
+						stty -a
+						/bin/sleep 1
+					done
+				`,
+				},
+				TTY: new(garden.TTYSpec),
+			}, garden.ProcessIO{
+				Stdout: stdout,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(stdout, "3s").Should(gbytes.Say("rows 24; columns 80;"))
+		})
+
+		It("executes the process with a raw tty with the given window size", func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+			stdout := gbytes.NewBuffer()
+			_, err := container.Run(garden.ProcessSpec{
+				Image: image(),
+				User:  user(),
+				Path:  shell,
+				Args: []string{
+					"-c",
+					`
+					# The mechanism that is used to set TTY size (ioctl) is
+					# asynchronous. Hence, stty does not return the correct result
+					# right after the process is launched.
+					while true; do
+						stty -a
+						/bin/sleep 1
+					done
+				`,
+				},
+				TTY: &garden.TTYSpec{
+					WindowSize: &garden.WindowSize{
+						Columns: 123,
+						Rows:    456,
+					},
+				},
+			}, garden.ProcessIO{
+				Stdout: stdout,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(stdout, "3s").Should(gbytes.Say("rows 456; columns 123;"))
+		})
+
+		It("executes the process with a raw tty and with onlcr to preserve formatting (\r\n, not just \n)", func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+			stdout := gbytes.NewBuffer()
+			_, err := container.Run(garden.ProcessSpec{
+				Image: image(),
+				Path:  shell,
+				Args: []string{
+					"-c",
+					`
+					while true; do
+						echo -e "new\nline"
+						/bin/sleep 1
+				  done
+				`,
+				},
+				TTY: &garden.TTYSpec{},
+			}, garden.ProcessIO{
+				Stdout: stdout,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(stdout).Should(gbytes.Say("new\r\nline"))
+		})
+
+		It("can have its terminal resized", func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+			skipIfContainerdForProcesses()
+			stdout := gbytes.NewBuffer()
+
+			inR, inW := io.Pipe()
+
+			process, err := container.Run(garden.ProcessSpec{
+				Image: image(),
+				User:  user(),
+				Path:  "sh",
+				Args: []string{
+					"-c",
+					`
+					trap "stty -a" SIGWINCH
+
+					# continuously read so that the trap can keep firing
+					while true; do
+						echo waiting
+						if read; then
+							exit 0
+						fi
+					done
+				`,
+				},
+				TTY: &garden.TTYSpec{
+					WindowSize: &garden.WindowSize{
+						Columns: 13,
+						Rows:    46,
+					},
+				},
+			}, garden.ProcessIO{
+				Stdin:  inR,
+				Stdout: stdout,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(stdout).Should(gbytes.Say("waiting"))
+
+			err = process.SetTTY(garden.TTYSpec{
+				WindowSize: &garden.WindowSize{
+					Columns: 123,
+					Rows:    456,
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Eventually(stdout).Should(gbytes.Say("rows 456; columns 123;"))
+
+			_, err = fmt.Fprintf(inW, "ok\n")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(process.Wait()).To(Equal(0))
+		})
+
+		It("all attached clients should get stdout and stderr", func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+			skipIfContainerdForProcesses()
+
+			var runStdout, attachStdout bytes.Buffer
+			stdinR, stdinW := io.Pipe()
+			defer stdinW.Close()
+
+			process, err := container.Run(garden.ProcessSpec{
+				Image: image(),
+				Path:  shell,
+				Args: []string{"-c", `
+read -s
+
+for i in $(seq 1 5); do
+echo $i
+echo $i >&2
+done
+				`},
+				TTY: new(garden.TTYSpec),
+			}, garden.ProcessIO{
+				Stdin:  stdinR,
+				Stdout: io.MultiWriter(&runStdout, GinkgoWriter),
+				Stderr: GinkgoWriter,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			attachedProcess, err := container.Attach(process.ID(), garden.ProcessIO{
+				Stdout: io.MultiWriter(&attachStdout, GinkgoWriter),
+				Stderr: GinkgoWriter,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = fmt.Fprintf(stdinW, "ok\n")
+			Expect(err).ToNot(HaveOccurred())
+
+			exitCode, err := process.Wait()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exitCode).To(Equal(0))
+
+			// Looks redundant, but avoids race as we have 2 representations of the process
+			exitCode, err = attachedProcess.Wait()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exitCode).To(Equal(0))
+
+			expected := `(ok\r\n)?1\r\n1\r\n2\r\n2\r\n3\r\n3\r\n4\r\n4\r\n5\r\n5\r\n`
+			Expect(runStdout.String()).To(MatchRegexp(expected), "run buffer:")
+			Expect(attachStdout.String()).To(MatchRegexp(expected), "attach buffer:")
+		})
+	})
+}
@@ -3,12 +3,16 @@ package garden_integration_tests_test
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"time"
 
 	"code.cloudfoundry.org/garden"
 	"code.cloudfoundry.org/guardian/rundmc/cgroups"
@@ -52,6 +56,76 @@ var _ = Describe("Limits", func() {
 		})
 	})
 
+	// The workloads compete for a single CPU, so nothing else may run while
+	// we measure how it is shared.
+	Describe("CPU weight fairness", Serial, func() {
+		const measureDuration = 10 * time.Second
+
+		var (
+			weights    []uint64
+			containers []garden.Container
+		)
+
+		BeforeEach(func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+			skipIfWoot("Groot does not support metrics yet")
+			// throttling could move a saturating container to the bad cgroup mid-measurement
+			if os.Getenv("CPU_THROTTLING_ENABLED") == "true" {
+				Skip("Skipping because CPU throttling is enabled")
+			}
+
+			weights = []uint64{1024, 512, 256}
+			limits.CPU = garden.CPULimits{Weight: weights[0]}
+			containers = nil
+		})
+
+		JustBeforeEach(func() {
+			containers = []garden.Container{container}
+			for _, weight := range weights[1:] {
+				c, err := gardenClient.Create(garden.ContainerSpec{
+					Limits: garden.Limits{CPU: garden.CPULimits{Weight: weight}},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				containers = append(containers, c)
+			}
+
+			for _, c := range containers {
+				streamInWorkload(c)
+				startWorkload(c, garden.ProcessSpec{User: "root"}, "burn-user", "0")
+			}
+		})
+
+		AfterEach(func() {
+			// the first one is the suite's container
+			for i := 1; i < len(containers); i++ {
+				Expect(destroyContainer(containers[i])).To(Succeed())
+			}
+		})
+
+		It("shares a saturated CPU in proportion to the weights", func() {
+			before := make([]uint64, len(containers))
+			for i, c := range containers {
+				before[i] = metrics(c).CPUStat.Usage
+			}
+			time.Sleep(measureDuration)
+
+			usages := make([]float64, len(containers))
+			for i, c := range containers {
+				usages[i] = float64(metrics(c).CPUStat.Usage - before[i])
+			}
+
+			for i := 1; i < len(containers); i++ {
+				expectedRatio := float64(cgroupCPUWeight(weights[i])) / float64(cgroupCPUWeight(weights[0]))
+				Expect(usages[i]/usages[0]).To(
+					BeNumerically("~", expectedRatio, expectedRatio/5),
+					fmt.Sprintf("weight %d relative to weight %d", weights[i], weights[0]),
+				)
+			}
+		})
+	})
+
 	Describe("memory limits", func() {
 		var tarStream io.Reader
 		BeforeEach(func() {
@@ -150,6 +224,104 @@ var _ = Describe("Limits", func() {
 		})
 	})
 
+	Describe("OOM events", func() {
+		var sibling garden.Container
+
+		BeforeEach(func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+			sibling = nil
+			limits = garden.Limits{Memory: garden.MemoryLimits{LimitInBytes: 64 * mb}}
+		})
+
+		JustBeforeEach(func() {
+			var err error
+			sibling, err = gardenClient.Create(garden.ContainerSpec{Limits: limits})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			if sibling != nil {
+				Expect(destroyContainer(sibling)).To(Succeed())
+			}
+		})
+
+		itReportsTheOOMEventOnTheContainer := func() {
+			It("reports a single oom event in the container info", func() {
+				Eventually(func() []string { return containerEvents(container) }).Should(ContainElement("oom"))
+				Consistently(func() []string { return containerEvents(container) }, "2s").Should(Equal([]string{"oom"}))
+			})
+
+			It("reports the oom event in bulk info for the container only", func() {
+				Eventually(func() []string {
+					return bulkContainerEvents(container.Handle(), sibling.Handle())[container.Handle()]
+				}).Should(Equal([]string{"oom"}))
+
+				Expect(bulkContainerEvents(container.Handle(), sibling.Handle())[sibling.Handle()]).To(BeEmpty())
+			})
+
+			It("does not report an oom event for sibling containers", func() {
+				Eventually(func() []string { return containerEvents(container) }).Should(ContainElement("oom"))
+				Expect(containerEvents(sibling)).NotTo(ContainElement("oom"))
+			})
+		}
+
+		It("does not report an oom event before any process exceeds the limit", func() {
+			Consistently(func() []string { return containerEvents(container) }, "2s").ShouldNot(ContainElement("oom"))
+		})
+
+		Context("when a process in the container exceeds the memory limit", func() {
+			JustBeforeEach(func() {
+				exitCode, _, _ := runProcess(container, garden.ProcessSpec{
+					User: "root",
+					Path: "dd",
+					Args: []string{"if=/dev/urandom", "of=/dev/shm/too-big", "bs=1M", "count=65"},
+				})
+				Expect(exitCode).NotTo(Equal(0))
+			})
+
+			itReportsTheOOMEventOnTheContainer()
+		})
+
+		Context("when a pea with its own memory limit exceeds that limit", func() {
+			var peaID string
+
+			BeforeEach(func() {
+				skipIfShed()
+				peaID = ""
+			})
+
+			JustBeforeEach(func() {
+				pea, err := container.Run(garden.ProcessSpec{
+					Path:  "dd",
+					Args:  []string{"if=/dev/urandom", "of=/dev/shm/too-big", "bs=1M", "count=33"},
+					Image: garden.ImageRef{URI: gardenRootfs},
+					OverrideContainerLimits: &garden.ProcessLimits{
+						Memory: garden.MemoryLimits{LimitInBytes: 32 * mb},
+					},
+				}, garden.ProcessIO{
+					Stdout: GinkgoWriter,
+					Stderr: GinkgoWriter,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				peaID = pea.ID()
+				Expect(pea.Wait()).NotTo(Equal(0))
+			})
+
+			itReportsTheOOMEventOnTheContainer()
+
+			It("reports the oom event once on the container, which is the only handle the pea has", func() {
+				Eventually(func() []string { return containerEvents(container) }).Should(ContainElement("oom"))
+				Consistently(func() []string { return containerEvents(container) }, "2s").Should(Equal([]string{"oom"}))
+
+				infos, err := gardenClient.BulkInfo([]string{peaID})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(infos[peaID].Err).To(HaveOccurred())
+			})
+		})
+	})
+
 	Describe("disk limits", func() {
 		BeforeEach(func() {
 			skipIfWoot("Groot does not support disk size limits yet")
@@ -353,6 +525,71 @@ var _ = Describe("Limits", func() {
 			})
 		})
 
+		Context("when files are streamed in", func() {
+			var exclusiveBytesUsed func() uint64
+
+			BeforeEach(func() {
+				if runtime.GOOS == "windows" {
+					Skip("pending for windows")
+				}
+				limits.Disk.ByteSoft = 10 * mb
+				limits.Disk.ByteHard = 10 * mb
+				limits.Disk.Scope = garden.DiskLimitScopeExclusive
+
+				exclusiveBytesUsed = func() uint64 {
+					metrics, err := container.Metrics()
+					Expect(err).ToNot(HaveOccurred())
+					return metrics.DiskStat.ExclusiveBytesUsed
+				}
+			})
+
+			Context("and the tarball fits in the quota", func() {
+				It("succeeds and counts the files towards the exclusive usage", func() {
+					initialBytes := exclusiveBytesUsed()
+
+					Expect(streamInFile(container, "fits", 5*mb)).To(Succeed())
+
+					stdout := runForStdout(container, garden.ProcessSpec{
+						User: "root",
+						Path: "stat",
+						Args: []string{"-c", "%s", "/tmp/fits"},
+					})
+					Expect(stdout).To(gbytes.Say(fmt.Sprintf("^%d\n", 5*mb)))
+
+					Eventually(exclusiveBytesUsed).Should(BeNumerically("~", initialBytes+5*mb, mb))
+				})
+			})
+
+			Context("and the tarball is larger than the quota", func() {
+				It("fails and does not count partial data", func() {
+					initialBytes := exclusiveBytesUsed()
+
+					Expect(streamInFile(container, "too-big", 15*mb)).NotTo(Succeed())
+
+					Eventually(exclusiveBytesUsed).Should(BeNumerically("~", initialBytes, mb))
+				})
+			})
+
+			Context("and the tarball is larger than the remaining quota", func() {
+				It("fails and does not count partial data", func() {
+					Expect(streamInFile(container, "fits", 6*mb)).To(Succeed())
+					Eventually(exclusiveBytesUsed).Should(BeNumerically(">=", 6*mb))
+					bytesAfterFirstStream := exclusiveBytesUsed()
+
+					Expect(streamInFile(container, "does-not-fit", 6*mb)).NotTo(Succeed())
+
+					Eventually(exclusiveBytesUsed).Should(BeNumerically("~", bytesAfterFirstStream, mb))
+
+					exitCode, _, _ := runProcess(container, garden.ProcessSpec{
+						User: "root",
+						Path: "test",
+						Args: []string{"-e", "/tmp/fits"},
+					})
+					Expect(exitCode).To(Equal(0))
+				})
+			})
+		})
+
 		Context("a rootfs with pre-existing users", func() {
 			BeforeEach(func() {
 				if runtime.GOOS == "windows" {
@@ -493,6 +730,126 @@ var _ = Describe("Limits", func() {
 		})
 	})
 
+	Describe("inode limits", func() {
+		BeforeEach(func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+			skipIfWoot("Groot does not support disk size limits yet")
+			privilegedContainer = false
+		})
+
+		// createFilesUntilFailure prints how many files it created before hitting the quota
+		const createFilesUntilFailure = `
+			mkdir -p /tmp/inodes
+			i=0
+			while [ $i -lt 10000 ]; do
+				touch /tmp/inodes/f$i || break
+				i=$((i+1))
+			done
+			echo $i
+		`
+
+		itReportsTheInodeLimits := func() {
+			It("reports the inode limits", func() {
+				diskLimits, err := container.CurrentDiskLimits()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(diskLimits.InodeSoft).To(Equal(limits.Disk.InodeSoft))
+				Expect(diskLimits.InodeHard).To(Equal(limits.Disk.InodeHard))
+			})
+		}
+
+		Context("when the scope is total", func() {
+			BeforeEach(func() {
+				imageRef.URI = limitsTestURI
+				limits.Disk.InodeSoft = 3000
+				limits.Disk.InodeHard = 3000
+				limits.Disk.Scope = garden.DiskLimitScopeTotal
+			})
+
+			itReportsTheInodeLimits()
+
+			It("counts the rootfs inodes towards the limit", func() {
+				metrics, err := container.Metrics()
+				Expect(err).NotTo(HaveOccurred())
+				initialInodes := metrics.DiskStat.TotalInodesUsed
+				Expect(initialInodes).To(BeNumerically(">", 0))
+
+				exitCode, stdout, stderr := runProcess(container, garden.ProcessSpec{
+					User: "root",
+					Path: "sh",
+					Args: []string{"-c", createFilesUntilFailure},
+				})
+				Expect(exitCode).To(Equal(0))
+				Expect(stderr).To(gbytes.Say("Disk quota exceeded|No space left on device"))
+
+				created, err := strconv.ParseUint(strings.TrimSpace(string(stdout.Contents())), 10, 64)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(created + initialInodes).To(BeNumerically("~", limits.Disk.InodeHard, 20))
+			})
+		})
+
+		Context("when the scope is exclusive", func() {
+			BeforeEach(func() {
+				limits.Disk.InodeSoft = 500
+				limits.Disk.InodeHard = 500
+				limits.Disk.Scope = garden.DiskLimitScopeExclusive
+			})
+
+			itReportsTheInodeLimits()
+
+			It("does not count the rootfs inodes towards the limit", func() {
+				exitCode, stdout, stderr := runProcess(container, garden.ProcessSpec{
+					User: "root",
+					Path: "sh",
+					Args: []string{"-c", createFilesUntilFailure},
+				})
+				Expect(exitCode).To(Equal(0))
+				Expect(stderr).To(gbytes.Say("Disk quota exceeded|No space left on device"))
+
+				created, err := strconv.ParseUint(strings.TrimSpace(string(stdout.Contents())), 10, 64)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(created).To(BeNumerically("~", limits.Disk.InodeHard, 20))
+			})
+
+			DescribeTable("Metrics",
+				func(reporter func() uint64) {
+					initialInodes := reporter()
+
+					exitCode, _, _ := runProcess(container, garden.ProcessSpec{
+						User: "root",
+						Path: "sh",
+						Args: []string{"-c", "mkdir /tmp/inodes && for i in $(seq 1 100); do touch /tmp/inodes/f$i; done"},
+					})
+					Expect(exitCode).To(Equal(0))
+
+					Eventually(reporter).Should(BeNumerically("~", initialInodes+101, 5))
+
+					exitCode, _, _ = runProcess(container, garden.ProcessSpec{
+						User: "root",
+						Path: "rm",
+						Args: []string{"-rf", "/tmp/inodes"},
+					})
+					Expect(exitCode).To(Equal(0))
+
+					Eventually(reporter).Should(BeNumerically("~", initialInodes, 5))
+				},
+
+				Entry("with exclusive metrics", func() uint64 {
+					metrics, err := container.Metrics()
+					Expect(err).ToNot(HaveOccurred())
+					return metrics.DiskStat.ExclusiveInodesUsed
+				}),
+
+				Entry("with total metrics", func() uint64 {
+					metrics, err := container.Metrics()
+					Expect(err).ToNot(HaveOccurred())
+					return metrics.DiskStat.TotalInodesUsed
+				}),
+			)
+		})
+	})
+
 	Describe("PID limits", func() {
 		BeforeEach(func() {
 			if runtime.GOOS == "windows" {
@@ -520,6 +877,108 @@ var _ = Describe("Limits", func() {
 				Expect(exitCode).To(Equal(2))
 				Expect(stderr).To(gbytes.Say(`sh: (?:line \d+: )?can't fork`))
 			})
+
+			Context("when a process starts threads", func() {
+				JustBeforeEach(func() {
+					streamInWorkload(container)
+				})
+
+				It("counts the threads towards the current pids", func() {
+					skipIfWoot("Groot does not support metrics yet")
+					initialPids := metrics(container).PidStat.Current
+
+					startWorkload(container, garden.ProcessSpec{User: "root"}, "threads", "20")
+
+					Eventually(func() uint64 {
+						return metrics(container).PidStat.Current
+					}).Should(BeNumerically(">=", initialPids+20))
+				})
+
+				It("counts the threads towards the limit", func() {
+					exitCode, _, stderr := runProcess(container, workloadProcessSpec(garden.ProcessSpec{User: "root"}, "threads", "60"))
+
+					Expect(exitCode).NotTo(Equal(0))
+					Expect(stderr).To(gbytes.Say("failed to create new OS thread"))
+				})
+			})
+
+			Context("when a pea is run", func() {
+				var forkMany garden.ProcessSpec
+
+				BeforeEach(func() {
+					skipIfShed()
+					forkMany = garden.ProcessSpec{
+						Path:  "sh",
+						Args:  []string{"-c", "for i in `seq 1 50`; do /bin/sleep 2 & done"},
+						Image: garden.ImageRef{URI: gardenRootfs},
+					}
+				})
+
+				It("shares the pid limit with the sandbox", func() {
+					exitCode, _, stderr := runProcess(container, forkMany)
+
+					Expect(exitCode).To(Equal(2))
+					Expect(stderr).To(gbytes.Say(`sh: (?:line \d+: )?can't fork`))
+				})
+
+				Context("when it overrides the container limits", func() {
+					BeforeEach(func() {
+						forkMany.OverrideContainerLimits = &garden.ProcessLimits{
+							Memory: garden.MemoryLimits{LimitInBytes: 64 * mb},
+						}
+					})
+
+					// ProcessLimits has no pid limit, so the pea still inherits the container's
+					It("still shares the pid limit with the sandbox", func() {
+						exitCode, _, stderr := runProcess(container, forkMany)
+
+						Expect(exitCode).To(Equal(2))
+						Expect(stderr).To(gbytes.Say(`sh: (?:line \d+: )?can't fork`))
+					})
+				})
+			})
+
+			Context("when the limit has been reached", func() {
+				BeforeEach(func() {
+					skipIfWoot("Groot does not support metrics yet")
+				})
+
+				JustBeforeEach(func() {
+					_, err := container.Run(garden.ProcessSpec{
+						User: "root",
+						Path: "sh",
+						Args: []string{"-c", "for i in `seq 1 60`; do /bin/sleep 1000 & done; wait"},
+					}, garden.ProcessIO{
+						Stdout: GinkgoWriter,
+						Stderr: GinkgoWriter,
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Eventually(func() uint64 {
+						return metrics(container).PidStat.Current
+					}).Should(BeEquivalentTo(limits.Pid.Max))
+				})
+
+				It("returns a clear error when running another process", func() {
+					_, err := container.Run(garden.ProcessSpec{
+						User: "root",
+						Path: "true",
+					}, garden.ProcessIO{
+						Stdout: GinkgoWriter,
+						Stderr: GinkgoWriter,
+					})
+					Expect(err).To(MatchError(MatchRegexp(`(?i)resource temporarily unavailable`)))
+				})
+
+				It("can still stop the container", func() {
+					Expect(container.Stop(false)).To(Succeed())
+				})
+
+				It("can still destroy the container", func() {
+					Expect(gardenClient.Destroy(container.Handle())).To(Succeed())
+					Expect(getContainerHandles()).NotTo(ContainElement(container.Handle()))
+				})
+			})
 		})
 
 		Context("when the pid limit is set to 0", func() {
@@ -616,6 +1075,18 @@ type archiveFile struct {
 	Link string
 }
 
+// streamInFile streams a tarball containing a single file of the given size into /tmp
+func streamInFile(container garden.Container, name string, size int) error {
+	var archive bytes.Buffer
+	writeTar(&archive, []archiveFile{{Name: "./" + name, Body: make([]byte, size), Mode: 0644}})
+
+	return container.StreamIn(garden.StreamInSpec{
+		Path:      "/tmp",
+		User:      "root",
+		TarStream: &archive,
+	})
+}
+
 func createTarGZArchive(filename string, files []archiveFile) {
 	file, err := os.Create(filename)
 	Expect(err).NotTo(HaveOccurred())
@@ -673,3 +1144,29 @@ func writeTar(destination io.Writer, files []archiveFile) {
 	err := w.Close()
 	Expect(err).NotTo(HaveOccurred())
 }
+
+// cgroupCPUWeight is the value the kernel uses to share CPU for a garden weight
+func cgroupCPUWeight(weight uint64) uint64 {
+	if cgroups.IsCgroup2UnifiedMode() {
+		return cgroups.ConvertCPUSharesToCgroupV2Value(weight)
+	}
+	return weight
+}
+
+func containerEvents(container garden.Container) []string {
+	info, err := container.Info()
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return info.Events
+}
+
+func bulkContainerEvents(handles ...string) map[string][]string {
+	infos, err := gardenClient.BulkInfo(handles)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+	events := map[string][]string{}
+	for handle, entry := range infos {
+		ExpectWithOffset(1, entry.Err).NotTo(HaveOccurred())
+		events[handle] = entry.Info.Events
+	}
+	return events
+}
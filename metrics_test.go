@@ -1,10 +1,17 @@
 package garden_integration_tests_test
 
 import (
+	"bytes"
+	"fmt"
+	"net"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
 	"code.cloudfoundry.org/garden"
+	"code.cloudfoundry.org/guardian/rundmc/cgroups"
+	sigar "github.com/cloudfoundry/gosigar"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -74,6 +81,131 @@ var _ = Describe("Metrics", func() {
 		})
 	})
 
+	// NetworkStat is nil when the server is not configured with
+	// --enable-container-network-metrics, and on platforms which do not
+	// collect network metrics (e.g. windows).
+	Describe("network metrics", func() {
+		const transferSize = 5 * mb
+
+		BeforeEach(func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+		})
+
+		JustBeforeEach(func() {
+			if metrics(container).NetworkStat == nil {
+				Skip("Skipping because container network metrics are not enabled on the server")
+			}
+		})
+
+		Context("when data is received through a NetIn mapping", func() {
+			var initialRx uint64
+
+			JustBeforeEach(func() {
+				initialRx = metrics(container).NetworkStat.RxBytes
+
+				hostPort, _, err := container.NetIn(0, 8080)
+				Expect(err).NotTo(HaveOccurred())
+
+				process, err := container.Run(garden.ProcessSpec{
+					User: "root",
+					Path: "sh",
+					Args: []string{"-c", "nc -l -p 8080 > /dev/null"},
+				}, garden.ProcessIO{
+					Stdout: GinkgoWriter,
+					Stderr: GinkgoWriter,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				sendToHostPort(hostPort, transferSize)
+				Expect(process.Wait()).To(Equal(0))
+			})
+
+			It("reports the received bytes", func() {
+				Eventually(func() uint64 {
+					return metrics(container).NetworkStat.RxBytes - initialRx
+				}).Should(BeNumerically("~", transferSize*1.05, transferSize*0.05))
+			})
+
+			It("reports the received bytes in bulk metrics", func() {
+				Eventually(func() (uint64, error) {
+					stat, err := bulkNetworkStat(container)
+					return stat.RxBytes - initialRx, err
+				}).Should(BeNumerically("~", transferSize*1.05, transferSize*0.05))
+			})
+		})
+
+		Context("when data is sent through a NetOut-permitted connection", func() {
+			var (
+				initialTx uint64
+				sink      garden.Container
+			)
+
+			JustBeforeEach(func() {
+				var err error
+				sink, err = gardenClient.Create(garden.ContainerSpec{})
+				Expect(err).NotTo(HaveOccurred())
+
+				sinkInfo, err := sink.Info()
+				Expect(err).NotTo(HaveOccurred())
+
+				sinkProcess, err := sink.Run(garden.ProcessSpec{
+					User: "root",
+					Path: "sh",
+					Args: []string{"-c", "nc -l -p 8080 > /dev/null"},
+				}, garden.ProcessIO{
+					Stdout: GinkgoWriter,
+					Stderr: GinkgoWriter,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(container.NetOut(garden.NetOutRule{
+					Protocol: garden.ProtocolTCP,
+					Networks: []garden.IPRange{garden.IPRangeFromIP(net.ParseIP(sinkInfo.ContainerIP))},
+					Ports:    []garden.PortRange{garden.PortRangeFromPort(8080)},
+				})).To(Succeed())
+
+				// the sink only takes one connection, so send once it is listening rather than retrying
+				Eventually(func() int {
+					exitCode, _, _ := runProcess(sink, garden.ProcessSpec{
+						User: "root",
+						Path: "sh",
+						Args: []string{"-c", "netstat -ltn | grep -q ':8080 '"},
+					})
+					return exitCode
+				}).Should(Equal(0))
+
+				initialTx = metrics(container).NetworkStat.TxBytes
+
+				exitCode, _, _ := runProcess(container, garden.ProcessSpec{
+					User: "root",
+					Path: "sh",
+					Args: []string{"-c", fmt.Sprintf("dd if=/dev/zero bs=1024 count=%d | nc -w3 %s 8080", transferSize/1024, sinkInfo.ContainerIP)},
+				})
+				Expect(exitCode).To(Equal(0))
+				Expect(sinkProcess.Wait()).To(Equal(0))
+			})
+
+			AfterEach(func() {
+				Expect(destroyContainer(sink)).To(Succeed())
+			})
+
+			It("reports the transmitted bytes", func() {
+				Eventually(func() uint64 {
+					return metrics(container).NetworkStat.TxBytes - initialTx
+				}).Should(BeNumerically("~", transferSize*1.05, transferSize*0.05))
+			})
+
+			It("reports the transmitted bytes in bulk metrics", func() {
+				Eventually(func() (uint64, error) {
+					stat, err := bulkNetworkStat(container)
+					return stat.TxBytes - initialTx, err
+				}).Should(BeNumerically("~", transferSize*1.05, transferSize*0.05))
+			})
+		})
+	})
+
 	It("returns container age", func() {
 		Eventually(func() time.Duration {
 			return metrics(container).Age
@@ -98,8 +230,253 @@ var _ = Describe("Metrics", func() {
 	})
 })
 
+var _ = Describe("Memory metrics", func() {
+	const (
+		memoryLimit  = 256 * mb
+		workloadSize = 100
+		tolerance    = 20 * mb
+	)
+
+	BeforeEach(func() {
+		if runtime.GOOS == "windows" {
+			Skip("pending for windows")
+		}
+		limits = garden.Limits{Memory: garden.MemoryLimits{LimitInBytes: memoryLimit}}
+	})
+
+	JustBeforeEach(func() {
+		skipIfWoot("Groot does not support metrics yet")
+		streamInWorkload(container)
+	})
+
+	It("reports the configured limit as the hierarchical memory limit", func() {
+		Eventually(func() uint64 {
+			return metrics(container).MemoryStat.HierarchicalMemoryLimit
+		}).Should(BeEquivalentTo(memoryLimit))
+	})
+
+	Context("when anonymous memory is allocated", func() {
+		var before garden.ContainerMemoryStat
+
+		JustBeforeEach(func() {
+			before = metrics(container).MemoryStat
+
+			process := startWorkload(container, garden.ProcessSpec{User: "root"}, "anon", strconv.Itoa(workloadSize))
+			DeferCleanup(func() {
+				Expect(process.Signal(garden.SignalTerminate)).To(Succeed())
+				Expect(process.Wait()).To(Equal(0))
+			})
+		})
+
+		It("counts it towards the limit", func() {
+			Eventually(func() uint64 {
+				return metrics(container).MemoryStat.TotalUsageTowardLimit
+			}).Should(BeNumerically(">=", before.TotalUsageTowardLimit+workloadSize*mb-tolerance))
+		})
+
+		It("reports it as anonymous memory", func() {
+			if cgroups.IsCgroup2UnifiedMode() {
+				Eventually(func() uint64 {
+					return metrics(container).MemoryStat.Anon
+				}).Should(BeNumerically("~", before.Anon+workloadSize*mb, tolerance))
+			} else {
+				Eventually(func() uint64 {
+					return metrics(container).MemoryStat.Rss
+				}).Should(BeNumerically("~", before.Rss+workloadSize*mb, tolerance))
+				Eventually(func() uint64 {
+					return metrics(container).MemoryStat.TotalRss
+				}).Should(BeNumerically("~", before.TotalRss+workloadSize*mb, tolerance))
+			}
+		})
+	})
+
+	Context("when files are read into the page cache", func() {
+		var before garden.ContainerMemoryStat
+
+		JustBeforeEach(func() {
+			before = metrics(container).MemoryStat
+
+			process := startWorkload(container, garden.ProcessSpec{User: "root"}, "page-cache", "/tmp/page-cache", strconv.Itoa(workloadSize))
+			DeferCleanup(func() {
+				Expect(process.Signal(garden.SignalTerminate)).To(Succeed())
+				Expect(process.Wait()).To(Equal(0))
+			})
+		})
+
+		It("reports it as file backed memory", func() {
+			if cgroups.IsCgroup2UnifiedMode() {
+				Eventually(func() uint64 {
+					return metrics(container).MemoryStat.File
+				}).Should(BeNumerically("~", before.File+workloadSize*mb, tolerance))
+			} else {
+				Eventually(func() uint64 {
+					return metrics(container).MemoryStat.TotalCache
+				}).Should(BeNumerically("~", before.TotalCache+workloadSize*mb, tolerance))
+			}
+		})
+
+		It("does not report it as anonymous memory", func() {
+			if cgroups.IsCgroup2UnifiedMode() {
+				Expect(metrics(container).MemoryStat.Anon).To(BeNumerically("<", before.Anon+tolerance))
+			} else {
+				Expect(metrics(container).MemoryStat.TotalRss).To(BeNumerically("<", before.TotalRss+tolerance))
+			}
+		})
+	})
+
+	Context("when swap is disabled on the host", func() {
+		BeforeEach(func() {
+			skipIfNotColocated()
+
+			swap := sigar.Swap{}
+			Expect(swap.Get()).To(Succeed())
+			if swap.Total != 0 {
+				Skip("Skipping because swap is enabled on the host")
+			}
+		})
+
+		It("reports no swap usage", func() {
+			startWorkload(container, garden.ProcessSpec{User: "root"}, "anon", strconv.Itoa(workloadSize))
+
+			memoryStat := metrics(container).MemoryStat
+			Expect(memoryStat.Swap).To(BeZero())
+			Expect(memoryStat.TotalSwap).To(BeZero())
+			Expect(memoryStat.SwapCached).To(BeZero())
+		})
+	})
+})
+
+var _ = Describe("CPU metrics", func() {
+	const burnDuration = 5 * time.Second
+
+	BeforeEach(func() {
+		if runtime.GOOS == "windows" {
+			Skip("pending for windows")
+		}
+		limits = garden.Limits{CPU: garden.CPULimits{Weight: 1024}}
+	})
+
+	JustBeforeEach(func() {
+		skipIfWoot("Groot does not support metrics yet")
+		streamInWorkload(container)
+	})
+
+	// burn runs the workload for burnDuration and returns how the CPU stats moved
+	burn := func(workload string) (delta garden.ContainerCPUStat) {
+		before := metrics(container).CPUStat
+
+		process := startWorkload(container, garden.ProcessSpec{User: "root"}, workload)
+		time.Sleep(burnDuration)
+		Expect(process.Signal(garden.SignalTerminate)).To(Succeed())
+		Expect(process.Wait()).To(Equal(0))
+
+		after := metrics(container).CPUStat
+		return garden.ContainerCPUStat{
+			Usage:  after.Usage - before.Usage,
+			User:   after.User - before.User,
+			System: after.System - before.System,
+		}
+	}
+
+	Context("when burning CPU in user space", func() {
+		It("accounts the time as user time", func() {
+			delta := burn("burn-user")
+
+			Expect(delta.User).To(BeNumerically(">", uint64(burnDuration)/2))
+			Expect(delta.User).To(BeNumerically(">", 5*delta.System))
+		})
+
+		It("reports usage as the sum of user and system time", func() {
+			delta := burn("burn-user")
+
+			Expect(delta.Usage).To(BeNumerically("~", delta.User+delta.System, delta.Usage/10))
+		})
+	})
+
+	Context("when burning CPU in the kernel", func() {
+		It("accounts the time as system time", func() {
+			delta := burn("burn-system")
+
+			Expect(delta.System).To(BeNumerically(">", uint64(burnDuration)/2))
+			Expect(delta.System).To(BeNumerically(">", 5*delta.User))
+		})
+
+		It("reports usage as the sum of user and system time", func() {
+			delta := burn("burn-system")
+
+			Expect(delta.Usage).To(BeNumerically("~", delta.User+delta.System, delta.Usage/10))
+		})
+	})
+
+	Describe("CPU entitlement", func() {
+		BeforeEach(func() {
+			// the entitlement depends on the memory and CPUs of the garden host
+			skipIfNotColocated()
+		})
+
+		JustBeforeEach(func() {
+			if metrics(container).CPUEntitlement == 0 {
+				Skip("Skipping because the server does not report CPU entitlement")
+			}
+		})
+
+		It("grows at the rate implied by the CPU weight relative to host memory", func() {
+			before := metrics(container).CPUEntitlement
+			start := time.Now()
+			time.Sleep(burnDuration)
+			after := metrics(container).CPUEntitlement
+			elapsed := time.Since(start)
+
+			// a container whose weight equals the host memory in megabytes is
+			// entitled to all of the host CPUs
+			expectedRate := float64(limits.CPU.Weight) / float64(totalMemoryInMegabytes()) * float64(hostCPUCount())
+			expected := expectedRate * float64(elapsed)
+
+			Expect(float64(after - before)).To(BeNumerically("~", expected, expected/10))
+		})
+	})
+})
+
 func metrics(container garden.Container) garden.Metrics {
 	metrics, err := container.Metrics()
 	Expect(err).NotTo(HaveOccurred())
 	return metrics
 }
+
+func bulkNetworkStat(container garden.Container) (garden.ContainerNetworkStat, error) {
+	metrics, err := gardenClient.BulkMetrics([]string{container.Handle()})
+	if err != nil {
+		return garden.ContainerNetworkStat{}, err
+	}
+
+	entry := metrics[container.Handle()]
+	if entry.Err != nil {
+		return garden.ContainerNetworkStat{}, entry.Err
+	}
+	if entry.Metrics.NetworkStat == nil {
+		return garden.ContainerNetworkStat{}, fmt.Errorf("no network metrics for %s", container.Handle())
+	}
+
+	return *entry.Metrics.NetworkStat, nil
+}
+
+func hostCPUCount() int {
+	cpus := sigar.CpuList{}
+	ExpectWithOffset(1, cpus.Get()).To(Succeed())
+	return len(cpus.List)
+}
+
+func sendToHostPort(hostPort uint32, size int) {
+	gardenHostname := strings.Split(gardenHost, ":")[0]
+
+	var conn net.Conn
+	Eventually(func() error {
+		var err error
+		conn, err = net.DialTimeout("tcp", fmt.Sprintf("%s:%d", gardenHostname, hostPort), 2*time.Second)
+		return err
+	}).Should(Succeed())
+	defer conn.Close()
+
+	_, err := conn.Write(bytes.Repeat([]byte{'x'}, size))
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+}
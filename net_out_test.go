@@ -0,0 +1,289 @@
+package garden_integration_tests_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"code.cloudfoundry.org/garden"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	googleDNSIPv6 = "2001:4860:4860::8888"
+
+	icmpEchoRequest      garden.ICMPType = 8
+	icmpTimestampRequest garden.ICMPType = 13
+
+	icmpv6EchoRequest            garden.ICMPType = 128
+	icmpv6MulticastListenerQuery garden.ICMPType = 130
+)
+
+var _ = Describe("NetOut", func() {
+	BeforeEach(func() {
+		if runtime.GOOS == "windows" {
+			Skip("pending for windows")
+		}
+	})
+
+	Describe("ICMP rules", func() {
+		var rule garden.NetOutRule
+
+		BeforeEach(func() {
+			skipIfNetworksNotDenied("ping", googleDNSIP)
+
+			rule = garden.NetOutRule{
+				Protocol: garden.ProtocolICMP,
+				Networks: []garden.IPRange{garden.IPRangeFromIP(net.ParseIP(googleDNSIP))},
+			}
+		})
+
+		JustBeforeEach(func() {
+			Expect(container.NetOut(rule)).To(Succeed())
+		})
+
+		Context("when only echo-request is allowed", func() {
+			BeforeEach(func() {
+				rule.ICMPs = &garden.ICMPControl{Type: icmpEchoRequest}
+			})
+
+			It("permits ping", func() {
+				Eventually(func() bool {
+					return pingSucceeds(container, "ping", googleDNSIP)
+				}).Should(BeTrue())
+			})
+
+			It("does not permit other protocols to the same destination", func() {
+				Expect(checkConnection(container, googleDNSIP, 53)).NotTo(Succeed())
+			})
+		})
+
+		Context("when only a different ICMP type is allowed", func() {
+			BeforeEach(func() {
+				rule.ICMPs = &garden.ICMPControl{Type: icmpTimestampRequest}
+			})
+
+			It("does not permit ping", func() {
+				Consistently(func() bool {
+					return pingSucceeds(container, "ping", googleDNSIP)
+				}, "5s", "1s").Should(BeFalse())
+			})
+		})
+
+		Context("when the code matches the echo-request code", func() {
+			BeforeEach(func() {
+				rule.ICMPs = &garden.ICMPControl{Type: icmpEchoRequest, Code: garden.ICMPControlCode(0)}
+			})
+
+			It("permits ping", func() {
+				Eventually(func() bool {
+					return pingSucceeds(container, "ping", googleDNSIP)
+				}).Should(BeTrue())
+			})
+		})
+
+		Context("when the code does not match the echo-request code", func() {
+			BeforeEach(func() {
+				rule.ICMPs = &garden.ICMPControl{Type: icmpEchoRequest, Code: garden.ICMPControlCode(1)}
+			})
+
+			It("does not permit ping", func() {
+				Consistently(func() bool {
+					return pingSucceeds(container, "ping", googleDNSIP)
+				}, "5s", "1s").Should(BeFalse())
+			})
+		})
+	})
+
+	Describe("ICMPv6 rules", func() {
+		var rule garden.NetOutRule
+
+		BeforeEach(func() {
+			rule = garden.NetOutRule{
+				Protocol: garden.ProtocolICMPv6,
+				Networks: []garden.IPRange{garden.IPRangeFromIP(net.ParseIP(googleDNSIPv6))},
+			}
+		})
+
+		JustBeforeEach(func() {
+			skipIfIPv6NotEnabled(container)
+			skipIfNetworksNotDenied("ping6", googleDNSIPv6)
+
+			Expect(container.NetOut(rule)).To(Succeed())
+		})
+
+		Context("when only echo-request is allowed", func() {
+			BeforeEach(func() {
+				rule.ICMPs = &garden.ICMPControl{Type: icmpv6EchoRequest}
+			})
+
+			It("permits ping", func() {
+				Eventually(func() bool {
+					return pingSucceeds(container, "ping6", googleDNSIPv6)
+				}).Should(BeTrue())
+			})
+
+			It("does not permit other protocols to the same destination", func() {
+				Expect(checkConnection(container, googleDNSIPv6, 53)).NotTo(Succeed())
+			})
+		})
+
+		Context("when only a different ICMPv6 type is allowed", func() {
+			BeforeEach(func() {
+				rule.ICMPs = &garden.ICMPControl{Type: icmpv6MulticastListenerQuery}
+			})
+
+			It("does not permit ping", func() {
+				Consistently(func() bool {
+					return pingSucceeds(container, "ping6", googleDNSIPv6)
+				}, "5s", "1s").Should(BeFalse())
+			})
+		})
+
+		Context("when the code does not match the echo-request code", func() {
+			BeforeEach(func() {
+				rule.ICMPs = &garden.ICMPControl{Type: icmpv6EchoRequest, Code: garden.ICMPControlCode(1)}
+			})
+
+			It("does not permit ping", func() {
+				Consistently(func() bool {
+					return pingSucceeds(container, "ping6", googleDNSIPv6)
+				}, "5s", "1s").Should(BeFalse())
+			})
+		})
+	})
+
+	Describe("logging", func() {
+		var (
+			rule      garden.NetOutRule
+			logSource kernelLogSource
+		)
+
+		BeforeEach(func() {
+			skipIfNotColocated()
+			logSource = netOutLogSource()
+
+			// iptables truncates log prefixes to 29 characters, so keep the handle short
+			handle = fmt.Sprintf("netout-log-%d-%d", GinkgoParallelProcess(), time.Now().UnixNano()%100000000)
+			rule = garden.NetOutRule{
+				Protocol: garden.ProtocolTCP,
+				Networks: []garden.IPRange{garden.IPRangeFromIP(net.ParseIP(googleDNSIP))},
+				Ports:    []garden.PortRange{garden.PortRangeFromPort(53)},
+				Log:      true,
+			}
+		})
+
+		JustBeforeEach(func() {
+			Expect(container.NetOut(rule)).To(Succeed())
+			Expect(checkConnection(container, googleDNSIP, 53)).To(Succeed())
+		})
+
+		It("logs the connection with the container handle as the prefix", func() {
+			Eventually(logSource.Contents, "30s", "1s").Should(MatchRegexp(
+				fmt.Sprintf(`%s.*DST=%s.*PROTO=TCP.*DPT=53`, handle, googleDNSIP),
+			))
+		})
+
+		Context("when the rule applies to all protocols", func() {
+			BeforeEach(func() {
+				rule.Protocol = garden.ProtocolAll
+				rule.Ports = nil
+			})
+
+			It("logs the connection with the container handle as the prefix", func() {
+				Eventually(logSource.Contents, "30s", "1s").Should(MatchRegexp(
+					fmt.Sprintf(`%s.*DST=%s.*DPT=53`, handle, googleDNSIP),
+				))
+			})
+		})
+
+		Context("when logging is not requested", func() {
+			BeforeEach(func() {
+				rule.Log = false
+			})
+
+			It("does not log the connection", func() {
+				Consistently(logSource.Contents, "10s", "1s").ShouldNot(ContainSubstring(handle))
+			})
+		})
+	})
+})
+
+// kernelLogSource reads the kernel log in which iptables LOG targets end up.
+// It is selected with GDN_NETOUT_LOG_SOURCE, which is either "journal" or the
+// path to a log file. It defaults to /var/log/kern.log.
+type kernelLogSource interface {
+	Contents() (string, error)
+}
+
+type journalLogSource struct{}
+
+func (journalLogSource) Contents() (string, error) {
+	output, err := exec.Command("journalctl", "--dmesg", "--no-pager", "--since", "-10min").Output()
+	return string(output), err
+}
+
+type fileLogSource struct {
+	path string
+}
+
+func (s fileLogSource) Contents() (string, error) {
+	contents, err := os.ReadFile(s.path)
+	return string(contents), err
+}
+
+func netOutLogSource() kernelLogSource {
+	switch source := os.Getenv("GDN_NETOUT_LOG_SOURCE"); source {
+	case "":
+		return fileLogSource{path: "/var/log/kern.log"}
+	case "journal":
+		return journalLogSource{}
+	default:
+		return fileLogSource{path: source}
+	}
+}
+
+func pingSucceeds(container garden.Container, pingBinary, ip string) bool {
+	exitCode, _, _ := runProcess(container, garden.ProcessSpec{
+		User: "root",
+		Path: pingBinary,
+		Args: []string{"-c", "1", "-W", "2", ip},
+	})
+
+	return exitCode == 0
+}
+
+// NetOut rules only have an observable effect when the server is configured
+// with deny_networks, so we probe a rule-less container first.
+func skipIfNetworksNotDenied(pingBinary, ip string) {
+	probe, err := gardenClient.Create(garden.ContainerSpec{})
+	Expect(err).NotTo(HaveOccurred())
+	defer func() {
+		Expect(gardenClient.Destroy(probe.Handle())).To(Succeed())
+	}()
+
+	if pingSucceeds(probe, pingBinary, ip) {
+		Skip("Skipping because the server does not deny outbound networks by default")
+	}
+
+	Expect(probe.NetOut(garden.NetOutRule{
+		Protocol: garden.ProtocolAll,
+		Networks: []garden.IPRange{garden.IPRangeFromIP(net.ParseIP(ip))},
+	})).To(Succeed())
+	if !pingSucceeds(probe, pingBinary, ip) {
+		Skip(fmt.Sprintf("Skipping because the server cannot reach %s even when it is allowed", ip))
+	}
+}
+
+func skipIfIPv6NotEnabled(container garden.Container) {
+	info, err := container.Info()
+	Expect(err).NotTo(HaveOccurred())
+
+	if info.ContainerIPv6 == "" {
+		Skip("Skipping because IPv6 is not enabled on the server")
+	}
+}
@@ -3,7 +3,12 @@ package garden_integration_tests_test
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"net"
+	"os"
 	"os/exec"
+	"path"
+	"regexp"
 	"runtime"
 	"strings"
 	"time"
@@ -49,6 +54,140 @@ var _ = Describe("Networking", func() {
 		}).ShouldNot(HaveOccurred())
 	})
 
+	Describe("NetIn", func() {
+		Context("when an explicit host port is requested", func() {
+			It("maps that host port and reports it in the container info", func() {
+				port := explicitHostPort(10)
+				hostPort, containerPort, err := container.NetIn(port, 8080)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(hostPort).To(Equal(port))
+				Expect(containerPort).To(BeEquivalentTo(8080))
+
+				Expect(mappedPorts(container)).To(ConsistOf(garden.PortMapping{HostPort: port, ContainerPort: 8080}))
+
+				listenInContainer(container, 8080, "hallo")
+				Eventually(func() (string, error) {
+					return readFromHostPort(port)
+				}).Should(ContainSubstring("hallo"))
+			})
+		})
+
+		Context("when the host port is zero", func() {
+			It("acquires a host port from the pool and reports it in the container info", func() {
+				hostPort, containerPort, err := container.NetIn(0, 8080)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(hostPort).NotTo(BeZero())
+				Expect(containerPort).To(BeEquivalentTo(8080))
+
+				Expect(mappedPorts(container)).To(ConsistOf(garden.PortMapping{HostPort: hostPort, ContainerPort: 8080}))
+			})
+		})
+
+		Context("when the container port is zero", func() {
+			It("uses the host port as the container port", func() {
+				port := explicitHostPort(11)
+				hostPort, containerPort, err := container.NetIn(port, 0)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(hostPort).To(Equal(port))
+				Expect(containerPort).To(Equal(port))
+
+				Expect(mappedPorts(container)).To(ConsistOf(garden.PortMapping{HostPort: port, ContainerPort: port}))
+			})
+		})
+
+		Context("when the same container port is mapped repeatedly", func() {
+			It("maps distinct host ports that all forward to the container port", func() {
+				firstHostPort, _, err := container.NetIn(0, 8080)
+				Expect(err).NotTo(HaveOccurred())
+				secondHostPort, _, err := container.NetIn(0, 8080)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(firstHostPort).NotTo(Equal(secondHostPort))
+
+				Expect(mappedPorts(container)).To(ConsistOf(
+					garden.PortMapping{HostPort: firstHostPort, ContainerPort: 8080},
+					garden.PortMapping{HostPort: secondHostPort, ContainerPort: 8080},
+				))
+
+				listenInContainer(container, 8080, "hallo")
+				for _, hostPort := range []uint32{firstHostPort, secondHostPort} {
+					Eventually(func() (string, error) {
+						return readFromHostPort(hostPort)
+					}).Should(ContainSubstring("hallo"))
+				}
+			})
+		})
+
+		Context("when the host port is already mapped by another container", func() {
+			var (
+				otherContainer garden.Container
+				port           uint32
+			)
+
+			JustBeforeEach(func() {
+				port = explicitHostPort(12)
+
+				var err error
+				otherContainer, err = gardenClient.Create(garden.ContainerSpec{})
+				Expect(err).NotTo(HaveOccurred())
+
+				_, _, err = otherContainer.NetIn(port, 8080)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			AfterEach(func() {
+				Expect(destroyContainer(otherContainer)).To(Succeed())
+			})
+
+			It("returns an error and does not report the mapping", func() {
+				_, _, err := container.NetIn(port, 8080)
+				Expect(err).To(HaveOccurred())
+
+				Expect(mappedPorts(container)).To(BeEmpty())
+			})
+		})
+
+		Context("when the container is destroyed", func() {
+			var port uint32
+
+			JustBeforeEach(func() {
+				port = explicitHostPort(13)
+
+				_, _, err := container.NetIn(port, 8080)
+				Expect(err).NotTo(HaveOccurred())
+				listenInContainer(container, 8080, "hallo")
+				Eventually(func() (string, error) {
+					return readFromHostPort(port)
+				}).Should(ContainSubstring("hallo"))
+
+				Expect(gardenClient.Destroy(container.Handle())).To(Succeed())
+			})
+
+			It("removes the mapping", func() {
+				Eventually(func() error {
+					_, err := readFromHostPort(port)
+					return err
+				}).Should(HaveOccurred())
+			})
+
+			It("allows the freed host port to be mapped again", func() {
+				newContainer, err := gardenClient.Create(garden.ContainerSpec{})
+				Expect(err).NotTo(HaveOccurred())
+				defer func() {
+					Expect(gardenClient.Destroy(newContainer.Handle())).To(Succeed())
+				}()
+
+				hostPort, _, err := newContainer.NetIn(port, 8080)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(hostPort).To(Equal(port))
+
+				listenInContainer(newContainer, 8080, "bonjour")
+				Eventually(func() (string, error) {
+					return readFromHostPort(port)
+				}).Should(ContainSubstring("bonjour"))
+			})
+		})
+	})
+
 	It("container root can overwrite /etc/hosts", func() {
 		exitCode, _, _ := runProcess(container, garden.ProcessSpec{
 			Path: "sh",
@@ -165,6 +304,195 @@ var _ = Describe("Networking", func() {
 		})
 	})
 
+	Describe("network interface properties", func() {
+		It("has the MTU configured on the server", func() {
+			expectedMTU := os.Getenv("GDN_MTU")
+			if expectedMTU == "" {
+				Skip("Skipping because GDN_MTU is not set")
+			}
+
+			stdout := runForStdout(container, garden.ProcessSpec{
+				User: "root",
+				Path: "sh",
+				Args: []string{"-c", fmt.Sprintf("cat /sys/class/net/$(%s)/mtu", defaultInterfaceCmd)},
+			})
+			Expect(stdout).To(gbytes.Say(fmt.Sprintf("^%s\n", expectedMTU)))
+		})
+
+		It("routes traffic through the host IP by default", func() {
+			info, err := container.Info()
+			Expect(err).NotTo(HaveOccurred())
+
+			stdout := runForStdout(container, garden.ProcessSpec{
+				User: "root",
+				Path: "ip",
+				Args: []string{"route", "show", "default"},
+			})
+			Expect(stdout).To(gbytes.Say(fmt.Sprintf(`default via %s `, regexp.QuoteMeta(info.HostIP))))
+		})
+
+		itHasTheNetworkConfigFiles := func(image func() garden.ImageRef) {
+			It("has the container IP and the handle-derived hostname in /etc/hosts", func() {
+				hosts := readFileInContainer(container, "/etc/hosts", image())
+				Expect(hosts).To(MatchRegexp(`(?m)^%s\s+%s$`, regexp.QuoteMeta(containerIP(container)), regexp.QuoteMeta(container.Handle())))
+			})
+
+			It("has the nameservers configured on the server in /etc/resolv.conf", func() {
+				dnsServers := os.Getenv("GDN_DNS_SERVERS")
+				if dnsServers == "" {
+					Skip("Skipping because GDN_DNS_SERVERS is not set")
+				}
+
+				var expectedNameservers []string
+				for _, server := range strings.Split(dnsServers, ",") {
+					expectedNameservers = append(expectedNameservers, "nameserver "+strings.TrimSpace(server))
+				}
+
+				var nameservers []string
+				for _, line := range strings.Split(readFileInContainer(container, "/etc/resolv.conf", image()), "\n") {
+					if strings.HasPrefix(line, "nameserver ") {
+						nameservers = append(nameservers, strings.TrimSpace(line))
+					}
+				}
+				Expect(nameservers).To(Equal(expectedNameservers))
+			})
+		}
+
+		itHasTheNetworkConfigFiles(func() garden.ImageRef { return garden.ImageRef{} })
+
+		Context("when running a pea", func() {
+			BeforeEach(func() {
+				skipIfShed()
+			})
+
+			itHasTheNetworkConfigFiles(func() garden.ImageRef { return garden.ImageRef{URI: gardenRootfs} })
+		})
+	})
+
+	Describe("NetIn and NetOut in the container spec", func() {
+		Context("when NetIn mappings are requested", func() {
+			var explicitPort uint32
+
+			BeforeEach(func() {
+				explicitPort = explicitHostPort(0)
+				netIn = []garden.NetIn{
+					{HostPort: 0, ContainerPort: 8080},
+					{HostPort: explicitPort, ContainerPort: 8081},
+				}
+			})
+
+			It("reports the mappings in the container info", func() {
+				info, err := container.Info()
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(info.MappedPorts).To(ContainElement(garden.PortMapping{HostPort: explicitPort, ContainerPort: 8081}))
+				Expect(info.MappedPorts).To(ContainElement(SatisfyAll(
+					HaveField("ContainerPort", BeEquivalentTo(8080)),
+					HaveField("HostPort", Not(BeZero())),
+				)))
+			})
+
+			It("forwards traffic from the host ports to the container", func() {
+				listenInContainer(container, 8080, "hallo")
+				listenInContainer(container, 8081, "bonjour")
+
+				info, err := container.Info()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.MappedPorts).To(HaveLen(2))
+
+				for _, mapping := range info.MappedPorts {
+					expected := "hallo"
+					if mapping.ContainerPort == 8081 {
+						expected = "bonjour"
+					}
+					Eventually(func() (string, error) {
+						return readFromHostPort(mapping.HostPort)
+					}).Should(ContainSubstring(expected))
+				}
+			})
+		})
+
+		Context("when NetOut rules are requested", func() {
+			BeforeEach(func() {
+				skipIfNetworksNotDenied("ping", googleDNSIP)
+
+				netOut = []garden.NetOutRule{
+					{
+						Protocol: garden.ProtocolTCP,
+						Networks: []garden.IPRange{garden.IPRangeFromIP(net.ParseIP(googleDNSIP))},
+						Ports:    []garden.PortRange{garden.PortRangeFromPort(53)},
+					},
+				}
+			})
+
+			It("enforces them for the first process in the container", func() {
+				Expect(checkConnection(container, googleDNSIP, 53)).To(Succeed())
+				Expect(pingSucceeds(container, "ping", googleDNSIP)).To(BeFalse())
+			})
+		})
+
+		Context("when the requested NetIn mappings are invalid", func() {
+			BeforeEach(func() {
+				assertContainerCreate = false
+				handle = fmt.Sprintf("invalid-netin-%d-%d", GinkgoParallelProcess(), time.Now().UnixNano())
+			})
+
+			itFailsAtomically := func() {
+				It("fails to create the container", func() {
+					Expect(containerCreateErr).To(HaveOccurred())
+				})
+
+				It("does not leave a half-created container behind", func() {
+					Expect(getContainerHandles()).NotTo(ContainElement(handle))
+					_, err := gardenClient.Lookup(handle)
+					Expect(err).To(HaveOccurred())
+				})
+			}
+
+			Context("because the host port is out of range", func() {
+				BeforeEach(func() {
+					netIn = []garden.NetIn{{HostPort: 70000, ContainerPort: 8080}}
+				})
+
+				itFailsAtomically()
+			})
+
+			Context("because the same host port is requested twice", func() {
+				BeforeEach(func() {
+					port := explicitHostPort(1)
+					netIn = []garden.NetIn{
+						{HostPort: port, ContainerPort: 8080},
+						{HostPort: port, ContainerPort: 8081},
+					}
+				})
+
+				itFailsAtomically()
+			})
+
+			Context("because the host port is already mapped by another container", func() {
+				var otherContainer garden.Container
+
+				BeforeEach(func() {
+					port := explicitHostPort(2)
+
+					var err error
+					otherContainer, err = gardenClient.Create(garden.ContainerSpec{
+						NetIn: []garden.NetIn{{HostPort: port, ContainerPort: 8080}},
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					netIn = []garden.NetIn{{HostPort: port, ContainerPort: 8080}}
+				})
+
+				AfterEach(func() {
+					Expect(destroyContainer(otherContainer)).To(Succeed())
+				})
+
+				itFailsAtomically()
+			})
+		})
+	})
+
 	Describe("subnet support", func() {
 		BeforeEach(func() {
 			networkSpec = fmt.Sprintf("192.168.%d.0/24", 12+GinkgoParallelProcess())
@@ -193,6 +521,135 @@ var _ = Describe("Networking", func() {
 			})
 		})
 
+		Context("when another container is on the same subnet", func() {
+			var otherContainer garden.Container
+
+			JustBeforeEach(func() {
+				var err error
+				otherContainer, err = gardenClient.Create(garden.ContainerSpec{
+					Network: networkSpec,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				listenInContainer(otherContainer, 8080, "hallo")
+			})
+
+			AfterEach(func() {
+				Expect(destroyContainer(otherContainer)).To(Succeed())
+			})
+
+			It("can reach it by its container IP", func() {
+				Eventually(func() string {
+					return receiveFromContainerIP(container, containerIP(otherContainer), 8080)
+				}).Should(ContainSubstring("hallo"))
+			})
+
+			Context("and a third container tries to spoof its IP", func() {
+				var spoofer garden.Container
+
+				JustBeforeEach(func() {
+					var err error
+					spoofer, err = gardenClient.Create(garden.ContainerSpec{
+						Network: networkSpec,
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					listenInContainer(spoofer, 8080, "spoofed")
+				})
+
+				AfterEach(func() {
+					Expect(destroyContainer(spoofer)).To(Succeed())
+				})
+
+				It("cannot send packets with the other container's IP as their source", func() {
+					streamInSpoof(spoofer)
+
+					genuine := udpListenInContainer(container, 9000)
+					forged := udpListenInContainer(container, 9001)
+
+					Eventually(func() *gbytes.Buffer {
+						sendSpoofedUDP(spoofer, containerIP(spoofer), containerIP(container), 9000, "genuine")
+						return genuine
+					}, "10s", "1s").Should(gbytes.Say("genuine"))
+
+					for i := 0; i < 5; i++ {
+						sendSpoofedUDP(spoofer, containerIP(otherContainer), containerIP(container), 9001, "forged")
+					}
+					Consistently(forged, "5s").ShouldNot(gbytes.Say("forged"))
+				})
+
+				It("cannot divert traffic with spoofed ARP announcements", func() {
+					otherMAC := strings.TrimSpace(string(runForStdout(otherContainer, garden.ProcessSpec{
+						User: "root",
+						Path: "sh",
+						Args: []string{"-c", fmt.Sprintf("cat /sys/class/net/$(%s)/address", defaultInterfaceCmd)},
+					}).Contents()))
+
+					exitCode, _, _ := runProcess(spoofer, garden.ProcessSpec{
+						User: "root",
+						Path: "sh",
+						Args: []string{"-c", fmt.Sprintf("arping -c 3 -U -s %[1]s -I $(%[2]s) %[1]s", containerIP(otherContainer), defaultInterfaceCmd)},
+					})
+					Expect(exitCode).To(Equal(0))
+
+					Consistently(func() string {
+						return receiveFromContainerIP(container, containerIP(otherContainer), 8080)
+					}, "5s", "1s").Should(ContainSubstring("hallo"))
+
+					stdout := runForStdout(container, garden.ProcessSpec{
+						User: "root",
+						Path: "ip",
+						Args: []string{"neigh", "show", containerIP(otherContainer)},
+					})
+					Expect(string(stdout.Contents())).To(ContainSubstring(otherMAC))
+				})
+			})
+		})
+
+		Context("when another container is on a different subnet", func() {
+			var otherContainer garden.Container
+
+			BeforeEach(func() {
+				skipIfNetworksNotDenied("ping", googleDNSIP)
+			})
+
+			JustBeforeEach(func() {
+				var err error
+				otherContainer, err = gardenClient.Create(garden.ContainerSpec{
+					Network: fmt.Sprintf("192.168.%d.0/24", 80+GinkgoParallelProcess()),
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				listenInContainer(otherContainer, 8080, "hallo")
+			})
+
+			AfterEach(func() {
+				Expect(destroyContainer(otherContainer)).To(Succeed())
+			})
+
+			It("cannot reach it", func() {
+				Consistently(func() string {
+					return receiveFromContainerIP(container, containerIP(otherContainer), 8080)
+				}, "5s", "1s").ShouldNot(ContainSubstring("hallo"))
+			})
+
+			Context("when NetOut allows traffic to it", func() {
+				JustBeforeEach(func() {
+					Expect(container.NetOut(garden.NetOutRule{
+						Protocol: garden.ProtocolTCP,
+						Networks: []garden.IPRange{garden.IPRangeFromIP(net.ParseIP(containerIP(otherContainer)))},
+						Ports:    []garden.PortRange{garden.PortRangeFromPort(8080)},
+					})).To(Succeed())
+				})
+
+				It("can reach it by its container IP", func() {
+					Eventually(func() string {
+						return receiveFromContainerIP(container, containerIP(otherContainer), 8080)
+					}).Should(ContainSubstring("hallo"))
+				})
+			})
+		})
+
 		Context("when creating a container in a previously used subnet", func() {
 			var newContainer garden.Container
 
@@ -250,3 +707,107 @@ func checkPing(container garden.Container, ip string) error {
 
 	return err
 }
+
+// defaultInterfaceCmd prints the name of the interface the default route goes through
+const defaultInterfaceCmd = `ip route | awk '/default/ { print $5 }'`
+
+func containerIP(container garden.Container) string {
+	info, err := container.Info()
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return info.ContainerIP
+}
+
+func receiveFromContainerIP(container garden.Container, ip string, port int) string {
+	_, stdout, _ := runProcess(container, garden.ProcessSpec{
+		User: "root",
+		Path: "sh",
+		Args: []string{"-c", fmt.Sprintf("nc -w3 %s %d </dev/null", ip, port)},
+	})
+	return string(stdout.Contents())
+}
+
+func mappedPorts(container garden.Container) []garden.PortMapping {
+	info, err := container.Info()
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return info.MappedPorts
+}
+
+// explicitHostPort returns a host port outside of the server's port pool that
+// is unique to this ginkgo process.
+func explicitHostPort(offset int) uint32 {
+	return uint32(50000 + 100*GinkgoParallelProcess() + offset)
+}
+
+func listenInContainer(container garden.Container, port uint32, message string) {
+	_, err := container.Run(garden.ProcessSpec{
+		User: "root",
+		Path: "sh",
+		Args: []string{"-c", fmt.Sprintf("while true; do echo %s | nc -l -p %d; done", message, port)},
+	}, garden.ProcessIO{
+		Stdout: GinkgoWriter,
+		Stderr: GinkgoWriter,
+	})
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+}
+
+// udpListenInContainer collects the datagrams received on port in the returned buffer
+func udpListenInContainer(container garden.Container, port uint32) *gbytes.Buffer {
+	received := gbytes.NewBuffer()
+	_, err := container.Run(garden.ProcessSpec{
+		User: "root",
+		Path: "nc",
+		Args: []string{"-u", "-l", "-p", fmt.Sprint(port)},
+	}, garden.ProcessIO{
+		Stdout: io.MultiWriter(received, GinkgoWriter),
+		Stderr: GinkgoWriter,
+	})
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return received
+}
+
+// streamInSpoof copies the spoof plugin into the container's rootfs at spoofDir
+func streamInSpoof(container garden.Container) {
+	contents, err := os.ReadFile(spoofBin)
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+
+	var archive bytes.Buffer
+	writeTar(&archive, []archiveFile{{Name: "./spoof", Body: contents, Mode: 0755}})
+
+	ExpectWithOffset(1, container.StreamIn(garden.StreamInSpec{
+		Path:      spoofDir,
+		User:      "root",
+		TarStream: &archive,
+	})).To(Succeed())
+}
+
+// sendSpoofedUDP sends a datagram from a raw socket, so source can be any address
+func sendSpoofedUDP(container garden.Container, source, destination string, port uint32, message string) {
+	exitCode, _, _ := runProcess(container, garden.ProcessSpec{
+		User: "root",
+		Path: path.Join(spoofDir, "spoof"),
+		Args: []string{source, destination, fmt.Sprint(port), message},
+	})
+	ExpectWithOffset(1, exitCode).To(Equal(0))
+}
+
+func readFromHostPort(hostPort uint32) (string, error) {
+	gardenHostname := strings.Split(gardenHost, ":")[0]
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", gardenHostname, hostPort), 2*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		return "", err
+	}
+
+	// nc may keep the connection open after writing, so a read timeout is
+	// fine as long as something was received
+	contents, err := io.ReadAll(conn)
+	if len(contents) > 0 {
+		return string(contents), nil
+	}
+
+	return "", err
+}
@@ -2,17 +2,30 @@
 package garden_integration_tests_test
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 
 	"code.cloudfoundry.org/garden"
+	"code.cloudfoundry.org/garden/client"
+	"code.cloudfoundry.org/garden/client/connection"
+	"code.cloudfoundry.org/lager/v3"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
 )
 
 const (
+	kb = 1024
 	mb = 1024 * 1024
 	gb = mb * 1024
 )
@@ -196,6 +209,112 @@ var _ = Describe("Partially shared containers (peas)", func() {
 		})
 	})
 
+	Describe("pea process TTY, stdin and Attach", func() {
+		BeforeEach(func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+		})
+
+		itBehavesLikeAnInteractiveProcess(func() garden.ImageRef { return peaImage })
+
+		It("sends identical output to all attached clients", func() {
+			skipIfContainerdForProcesses()
+
+			var runStdout bytes.Buffer
+			attachStdouts := make([]*bytes.Buffer, 3)
+			stdinR, stdinW := io.Pipe()
+			defer stdinW.Close()
+
+			process, err := container.Run(garden.ProcessSpec{
+				Path: "sh",
+				Args: []string{"-c", `
+					# wait for all clients to attach before producing output
+					read -r _
+
+					for i in $(seq 1 10); do
+						echo $i
+					done
+				`},
+				Image: peaImage,
+			}, garden.ProcessIO{
+				Stdin:  stdinR,
+				Stdout: io.MultiWriter(&runStdout, GinkgoWriter),
+				Stderr: GinkgoWriter,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			attachedProcesses := make([]garden.Process, len(attachStdouts))
+			for i := range attachStdouts {
+				attachStdouts[i] = new(bytes.Buffer)
+				attachedProcesses[i], err = container.Attach(process.ID(), garden.ProcessIO{
+					Stdout: io.MultiWriter(attachStdouts[i], GinkgoWriter),
+					Stderr: GinkgoWriter,
+				})
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			_, err = fmt.Fprintf(stdinW, "go\n")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(process.Wait()).To(Equal(0))
+			for _, attachedProcess := range attachedProcesses {
+				Expect(attachedProcess.Wait()).To(Equal(0))
+			}
+
+			expected := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+			Expect(runStdout.String()).To(Equal(expected), "run buffer:")
+			for i, attachStdout := range attachStdouts {
+				Expect(attachStdout.String()).To(Equal(expected), fmt.Sprintf("attach buffer %d:", i))
+			}
+		})
+
+		It("can be re-attached to by process ID after the original client disconnects", func() {
+			skipIfContainerdForProcesses()
+
+			var (
+				originalConnsMu sync.Mutex
+				originalConns   []net.Conn
+			)
+			originalClient := client.New(connection.NewWithDialerAndLogger(func(string, string) (net.Conn, error) {
+				conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%s", gardenHost, gardenPort), 2*time.Second)
+				if err == nil {
+					originalConnsMu.Lock()
+					originalConns = append(originalConns, conn)
+					originalConnsMu.Unlock()
+				}
+				return conn, err
+			}, lager.NewLogger("original-client")))
+			originalContainer, err := originalClient.Lookup(container.Handle())
+			Expect(err).NotTo(HaveOccurred())
+
+			process, err := originalContainer.Run(garden.ProcessSpec{
+				Path:  "sh",
+				Args:  []string{"-c", "/bin/sleep 2; echo hello; exit 12"},
+				Image: peaImage,
+			}, garden.ProcessIO{Stdout: GinkgoWriter})
+			Expect(err).ToNot(HaveOccurred())
+			processID := process.ID()
+
+			originalConnsMu.Lock()
+			for _, conn := range originalConns {
+				// the server may have closed it already
+				_ = conn.Close()
+			}
+			originalConnsMu.Unlock()
+
+			stdout := gbytes.NewBuffer()
+			attachedProcess, err := container.Attach(processID, garden.ProcessIO{
+				Stdout: stdout,
+				Stderr: GinkgoWriter,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(attachedProcess.Wait()).To(Equal(12))
+			Expect(stdout).To(gbytes.Say("hello\n"))
+		})
+	})
+
 	It("bind mounts the same /etc/hosts file as the container", func() {
 		if runtime.GOOS == "windows" {
 			Skip("pending for windows")
@@ -241,6 +360,87 @@ var _ = Describe("Partially shared containers (peas)", func() {
 		})
 	})
 
+	Describe("BindMounts", func() {
+		var bindMount garden.BindMount
+
+		BeforeEach(func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+			bindMount = garden.BindMount{
+				SrcPath: "/tmp/pea-mount-src",
+				DstPath: "/pea-mount",
+				Mode:    garden.BindMountModeRO,
+				Origin:  garden.BindMountOriginContainer,
+			}
+		})
+
+		JustBeforeEach(func() {
+			exitCode, _, _ := runProcess(container, garden.ProcessSpec{
+				User: "root",
+				Path: "sh",
+				Args: []string{"-c", "mkdir -p /tmp/pea-mount-src && chmod 777 /tmp/pea-mount-src && echo hello from the sandbox > /tmp/pea-mount-src/sandbox-file"},
+			})
+			Expect(exitCode).To(Equal(0))
+		})
+
+		It("makes the mount visible to the pea", func() {
+			exitCode, stdout, _ := runProcess(container, garden.ProcessSpec{
+				Path:       "cat",
+				Args:       []string{"/pea-mount/sandbox-file"},
+				Image:      peaImage,
+				BindMounts: []garden.BindMount{bindMount},
+			})
+			Expect(exitCode).To(Equal(0))
+			Expect(stdout).To(gbytes.Say("hello from the sandbox"))
+		})
+
+		It("does not make the mount visible to the sandbox", func() {
+			exitCode, _, _ := runProcess(container, garden.ProcessSpec{
+				Path:       "true",
+				Image:      peaImage,
+				BindMounts: []garden.BindMount{bindMount},
+			})
+			Expect(exitCode).To(Equal(0))
+
+			exitCode, _, _ = runProcess(container, garden.ProcessSpec{
+				User: "root",
+				Path: "test",
+				Args: []string{"-e", "/pea-mount/sandbox-file"},
+			})
+			Expect(exitCode).NotTo(Equal(0))
+		})
+
+		It("does not allow writes to a read-only mount", func() {
+			exitCode, _, stderr := runProcess(container, garden.ProcessSpec{
+				Path:       "touch",
+				Args:       []string{"/pea-mount/pea-file"},
+				Image:      peaImage,
+				BindMounts: []garden.BindMount{bindMount},
+			})
+			Expect(exitCode).NotTo(Equal(0))
+			Expect(stderr).To(gbytes.Say("Read-only file system"))
+		})
+
+		Context("when the mount is read-write", func() {
+			BeforeEach(func() {
+				bindMount.Mode = garden.BindMountModeRW
+			})
+
+			It("makes writes from the pea visible to the sandbox", func() {
+				exitCode, _, _ := runProcess(container, garden.ProcessSpec{
+					Path:       "sh",
+					Args:       []string{"-c", "echo hello from the pea > /pea-mount/pea-file"},
+					Image:      peaImage,
+					BindMounts: []garden.BindMount{bindMount},
+				})
+				Expect(exitCode).To(Equal(0))
+
+				Expect(readFileInContainer(container, "/tmp/pea-mount-src/pea-file", noImage)).To(Equal("hello from the pea\n"))
+			})
+		})
+	})
+
 	Describe("signalling", func() {
 		It("sends a TERM signal to the process if requested", func() {
 			if runtime.GOOS == "windows" {
@@ -272,6 +472,135 @@ var _ = Describe("Partially shared containers (peas)", func() {
 		})
 	})
 
+	Describe("pea images", func() {
+		var (
+			peaSpec        garden.ProcessSpec
+			sandboxProcess garden.Process
+			psBefore       string
+			mountsBefore   int
+		)
+
+		BeforeEach(func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+			peaSpec = garden.ProcessSpec{Path: "true"}
+		})
+
+		JustBeforeEach(func() {
+			var err error
+			sandboxProcess, err = container.Run(garden.ProcessSpec{
+				Path: "/bin/sleep",
+				Args: []string{"10000d"},
+			}, garden.ProcessIO{
+				Stdout: GinkgoWriter,
+				Stderr: GinkgoWriter,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			psBefore = sandboxProcesses(container)
+			if colocated() {
+				mountsBefore = hostMountCount()
+			}
+		})
+
+		itFailsToRunThePea := func(errorMatcher func() OmegaMatcher) {
+			var runErr error
+
+			JustBeforeEach(func() {
+				_, runErr = container.Run(peaSpec, garden.ProcessIO{
+					Stdout: GinkgoWriter,
+					Stderr: GinkgoWriter,
+				})
+			})
+
+			It("returns an error from Run", func() {
+				Expect(runErr).To(MatchError(errorMatcher()))
+			})
+
+			It("does not leave a half-created pea behind", func() {
+				Expect(sandboxProcesses(container)).To(Equal(psBefore))
+				if colocated() {
+					Eventually(hostMountCount).Should(Equal(mountsBefore))
+				}
+			})
+
+			It("does not affect the processes running in the sandbox", func() {
+				exited := make(chan struct{})
+				go func() {
+					defer close(exited)
+					_, _ = sandboxProcess.Wait()
+				}()
+				Consistently(exited, "2s").ShouldNot(BeClosed())
+
+				exitCode, _, _ := runProcess(container, garden.ProcessSpec{Path: "true"})
+				Expect(exitCode).To(Equal(0))
+			})
+		}
+
+		Context("when the image is private", func() {
+			BeforeEach(func() {
+				peaSpec.Image = privatePeaImage()
+			})
+
+			It("pulls the image and runs the pea", func() {
+				exitCode, _, _ := runProcess(container, peaSpec)
+				Expect(exitCode).To(Equal(0))
+			})
+
+			Context("but the credentials are incorrect", func() {
+				BeforeEach(func() {
+					peaSpec.Image.Password = "not-" + peaSpec.Image.Password
+				})
+
+				itFailsToRunThePea(func() OmegaMatcher {
+					return MatchRegexp(`(?i)unauthorized|authentication|denied`)
+				})
+			})
+		})
+
+		Context("when the image does not exist", func() {
+			BeforeEach(func() {
+				peaSpec.Image = garden.ImageRef{URI: "docker:///cloudfoundry/garden-integration-tests-does-not-exist"}
+			})
+
+			itFailsToRunThePea(func() OmegaMatcher {
+				// Docker Hub does not reveal whether a repository exists without access to it
+				return MatchRegexp(`(?i)not found|manifest unknown|unauthorized|requested access to the resource is denied`)
+			})
+		})
+
+		Context("when the image is corrupt", func() {
+			var corruptImagePath string
+
+			BeforeEach(func() {
+				corruptImagePath = ""
+				skipIfNotColocated()
+
+				corruptImage, err := os.CreateTemp("", "corrupt-pea-image-*.tar")
+				Expect(err).NotTo(HaveOccurred())
+				defer corruptImage.Close()
+				// a rootfs tarball that is cut off in the middle of its only file
+				var archive bytes.Buffer
+				writeTar(&archive, []archiveFile{{Name: "./bin/pea", Body: bytes.Repeat([]byte{'x'}, 64*kb), Mode: 0755}})
+				_, err = corruptImage.Write(archive.Bytes()[:archive.Len()/2])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(corruptImage.Chmod(0644)).To(Succeed())
+
+				corruptImagePath = corruptImage.Name()
+				peaSpec.Image = garden.ImageRef{URI: corruptImagePath}
+			})
+
+			AfterEach(func() {
+				Expect(os.RemoveAll(corruptImagePath)).To(Succeed())
+			})
+
+			itFailsToRunThePea(func() OmegaMatcher {
+				return MatchRegexp(`(?i)unexpected EOF|archive/tar|invalid tar header`)
+			})
+		})
+	})
+
 	Describe("Limits", func() {
 		BeforeEach(func() {
 			if runtime.GOOS == "windows" {
@@ -350,6 +679,66 @@ var _ = Describe("Partially shared containers (peas)", func() {
 				Expect(exitCode).NotTo(Equal(0))
 			})
 		})
+
+		Context("when a CPU weight is specified on the pea", func() {
+			var cpuLimitedPea garden.ProcessSpec
+
+			BeforeEach(func() {
+				cpuLimitedPea = garden.ProcessSpec{
+					Image: peaImage,
+					OverrideContainerLimits: &garden.ProcessLimits{
+						CPU: garden.CPULimits{Weight: 512},
+					},
+				}
+			})
+
+			JustBeforeEach(func() {
+				streamInProbe(container)
+			})
+
+			It("places the pea in its own cgroup", func() {
+				sandboxCgroup := runProbe(container, garden.ProcessSpec{}, "cgroup")
+				peaCgroup := runProbe(container, cpuLimitedPea, "cgroup")
+
+				Expect(peaCgroup).NotTo(Equal(sandboxCgroup))
+			})
+
+			It("applies the requested weight to the pea cgroup", func() {
+				Expect(runProbe(container, cpuLimitedPea, "cpu-weight")).To(Equal(fmt.Sprint(cgroupCPUWeight(512))))
+			})
+
+			It("reports the entitlement for the requested weight in bulk metrics", func() {
+				skipIfWoot("Groot does not support metrics yet")
+				// the entitlement depends on the memory and CPUs of the garden host
+				skipIfNotColocated()
+
+				cpuLimitedPea.Path = "sleep"
+				cpuLimitedPea.Args = []string{"3600"}
+				proc, err := container.Run(cpuLimitedPea, garden.ProcessIO{
+					Stdout: GinkgoWriter,
+					Stderr: GinkgoWriter,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				handle := proc.ID()
+				peaEntitlement := func() uint64 {
+					metrics, err := gardenClient.BulkMetrics([]string{handle})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(metrics[handle].Err).NotTo(HaveOccurred())
+					return metrics[handle].Metrics.CPUEntitlement
+				}
+
+				before := peaEntitlement()
+				start := time.Now()
+				time.Sleep(5 * time.Second)
+				after := peaEntitlement()
+				elapsed := time.Since(start)
+
+				expectedRate := 512 / float64(totalMemoryInMegabytes()) * float64(hostCPUCount())
+				expected := expectedRate * float64(elapsed)
+				Expect(float64(after - before)).To(BeNumerically("~", expected, expected/10))
+			})
+		})
 	})
 
 	Context("when the sandbox is destroyed", func() {
@@ -373,6 +762,162 @@ var _ = Describe("Partially shared containers (peas)", func() {
 		})
 	})
 
+	// These specs compare server-wide state before and after, so they must not
+	// run alongside other specs.
+	Describe("cleanup", Serial, func() {
+		var (
+			schedulableDiskBefore uint64
+			mountsBefore          int
+			cgroupsBefore         int
+		)
+
+		BeforeEach(func() {
+			if runtime.GOOS == "windows" {
+				Skip("pending for windows")
+			}
+			skipIfWoot("Groot does not support capacity yet")
+
+			// without a disk limit nothing is reserved, so schedulable disk would never move
+			limits = garden.Limits{Disk: garden.DiskLimits{ByteHard: 200 * mb, Scope: garden.DiskLimitScopeExclusive}}
+
+			schedulableDiskBefore = capacity().SchedulableDiskInBytes
+			if colocated() {
+				mountsBefore = hostMountCount()
+				cgroupsBefore = hostCgroupCount()
+			}
+		})
+
+		Context("when a pea exits", func() {
+			var (
+				schedulableDiskWithSandbox uint64
+				stdinR                     *io.PipeReader
+				stdinW                     *io.PipeWriter
+			)
+
+			BeforeEach(func() {
+				stdinR, stdinW = io.Pipe()
+			})
+
+			JustBeforeEach(func() {
+				schedulableDiskWithSandbox = capacity().SchedulableDiskInBytes
+			})
+
+			AfterEach(func() {
+				stdinW.Close()
+			})
+
+			It("returns the schedulable disk used by the pea", func() {
+				Expect(schedulableDiskWithSandbox).To(BeNumerically("<", schedulableDiskBefore))
+
+				process, err := container.Run(garden.ProcessSpec{
+					Path:  "sh",
+					Args:  []string{"-c", "dd if=/dev/zero of=/tmp/pea-file bs=1M count=20 && read -r _"},
+					Image: peaImage,
+				}, garden.ProcessIO{
+					Stdin:  stdinR,
+					Stdout: GinkgoWriter,
+					Stderr: GinkgoWriter,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(func() uint64 {
+					return capacity().SchedulableDiskInBytes
+				}).Should(BeNumerically("<", schedulableDiskWithSandbox))
+
+				Expect(stdinW.Close()).To(Succeed())
+				Expect(process.Wait()).To(Equal(0))
+
+				Eventually(func() uint64 {
+					return capacity().SchedulableDiskInBytes
+				}).Should(Equal(schedulableDiskWithSandbox))
+			})
+
+			It("does not count the pea rootfs towards the container disk usage", func() {
+				metricsBefore, err := container.Metrics()
+				Expect(err).NotTo(HaveOccurred())
+
+				exitCode, _, _ := runProcess(container, garden.ProcessSpec{
+					Path:  "dd",
+					Args:  []string{"if=/dev/zero", "of=/tmp/pea-file", "bs=1M", "count=20"},
+					Image: peaImage,
+				})
+				Expect(exitCode).To(Equal(0))
+
+				Eventually(func() (uint64, error) {
+					metrics, err := container.Metrics()
+					return metrics.DiskStat.TotalBytesUsed, err
+				}).Should(BeNumerically("~", metricsBefore.DiskStat.TotalBytesUsed, mb))
+			})
+		})
+
+		Context("when many peas are run one after another", func() {
+			It("does not leak mounts, cgroups or disk", func() {
+				schedulableDiskWithSandbox := capacity().SchedulableDiskInBytes
+				Expect(schedulableDiskWithSandbox).To(BeNumerically("<", schedulableDiskBefore))
+				var mountsWithSandbox, cgroupsWithSandbox int
+				if colocated() {
+					mountsWithSandbox = hostMountCount()
+					cgroupsWithSandbox = hostCgroupCount()
+				}
+
+				for i := 0; i < 200; i++ {
+					exitCode, _, _ := runProcess(container, garden.ProcessSpec{
+						Path:  "true",
+						Image: peaImage,
+					})
+					Expect(exitCode).To(Equal(0), fmt.Sprintf("pea %d:", i))
+				}
+
+				Eventually(func() uint64 {
+					return capacity().SchedulableDiskInBytes
+				}).Should(Equal(schedulableDiskWithSandbox))
+
+				if colocated() {
+					Eventually(hostMountCount).Should(Equal(mountsWithSandbox))
+					Eventually(hostCgroupCount).Should(Equal(cgroupsWithSandbox))
+				}
+			})
+		})
+
+		Context("when the sandbox is destroyed while a pea is running", func() {
+			var peaID string
+
+			JustBeforeEach(func() {
+				process, err := container.Run(garden.ProcessSpec{
+					Path:  "/bin/sleep",
+					Args:  []string{"10000d"},
+					Image: peaImage,
+				}, garden.ProcessIO{
+					Stdout: GinkgoWriter,
+					Stderr: GinkgoWriter,
+				})
+				Expect(err).ToNot(HaveOccurred())
+				peaID = process.ID()
+
+				Expect(gardenClient.Destroy(container.Handle())).To(Succeed())
+			})
+
+			It("returns all schedulable disk", func() {
+				Eventually(func() uint64 {
+					return capacity().SchedulableDiskInBytes
+				}).Should(Equal(schedulableDiskBefore))
+			})
+
+			It("no longer reports metrics for the pea", func() {
+				metrics, err := gardenClient.BulkMetrics([]string{peaID})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(metrics[peaID].Err).To(HaveOccurred())
+			})
+
+			It("releases all mounts and cgroups", func() {
+				skipIfNotColocated()
+
+				Eventually(hostMountCount).Should(Equal(mountsBefore))
+				Eventually(hostCgroupCount).Should(Equal(cgroupsBefore))
+			})
+		})
+	})
+
 	Describe("Metrics", func() {
 		BeforeEach(func() {
 			if runtime.GOOS == "windows" {
@@ -438,6 +983,59 @@ var _ = Describe("Partially shared containers (peas)", func() {
 	})
 })
 
+// privatePeaImage points at a private image, which is the public docker hub
+// test image unless DOCKER_REGISTRY_PRIVATE_IMAGE points at a local registry
+func privatePeaImage() garden.ImageRef {
+	image := garden.ImageRef{
+		URI:      os.Getenv("DOCKER_REGISTRY_PRIVATE_IMAGE"),
+		Username: os.Getenv("DOCKER_REGISTRY_USERNAME"),
+		Password: os.Getenv("DOCKER_REGISTRY_PASSWORD"),
+	}
+	if image.Username == "" || image.Password == "" {
+		Skip("Registry username or password not provided")
+	}
+	if image.URI == "" {
+		image.URI = "docker:///cloudfoundry/garden-private-image-test"
+	}
+	return image
+}
+
+// sandboxProcesses lists the commands running in the sandbox, which shares
+// its pid namespace with its peas
+func sandboxProcesses(container garden.Container) string {
+	stdout := runForStdout(container, garden.ProcessSpec{
+		User: "root",
+		Path: "sh",
+		Args: []string{"-c", "ps -o comm | grep -v -e '^ps$' -e '^sh$' -e '^grep$' | sort"},
+	})
+	return string(stdout.Contents())
+}
+
+func hostMountCount() int {
+	contents, err := os.ReadFile("/proc/self/mounts")
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return strings.Count(string(contents), "\n")
+}
+
+func hostCgroupCount() int {
+	count := 0
+	err := filepath.WalkDir("/sys/fs/cgroup", func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// cgroups can disappear while we walk
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			count++
+		}
+		return nil
+	})
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	return count
+}
+
 func getNS(nsName string, container garden.Container, image garden.ImageRef) string {
 	processSpec := garden.ProcessSpec{
 		Path:  "readlink",
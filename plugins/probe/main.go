@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// probe reports on the cgroups of container processes, so that specs can
+// observe what the server configured from inside a container or a pea.
+func main() {
+	if len(os.Args) < 2 || len(os.Args) > 3 {
+		usage()
+	}
+
+	var (
+		out string
+		err error
+	)
+
+	switch os.Args[1] {
+	case "cgroup":
+		pid := "self"
+		if len(os.Args) == 3 {
+			pid, err = pidOf(os.Args[2])
+		}
+		if err == nil {
+			out, err = cpuCgroup(pid)
+		}
+	case "cpu-weight":
+		out, err = cpuWeight()
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Println(out)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: probe cgroup [process-name] | cpu-weight")
+	os.Exit(2)
+}
+
+// pidOf returns the pid of the first process whose executable has the given name
+func pidOf(name string) (string, error) {
+	cmdlines, err := filepath.Glob("/proc/[0-9]*/cmdline")
+	if err != nil {
+		return "", err
+	}
+
+	for _, cmdline := range cmdlines {
+		contents, err := os.ReadFile(cmdline)
+		if err != nil {
+			// the process has exited since we listed it
+			continue
+		}
+
+		argv0 := strings.SplitN(string(contents), "\x00", 2)[0]
+		if filepath.Base(argv0) == name {
+			return filepath.Base(filepath.Dir(cmdline)), nil
+		}
+	}
+
+	return "", fmt.Errorf("no process named %s", name)
+}
+
+// cpuCgroup returns the path of the cgroup controlling the CPU of the process
+func cpuCgroup(pid string) (string, error) {
+	contents, err := os.ReadFile(filepath.Join("/proc", pid, "cgroup"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+
+		// cgroups v2 has a single hierarchy with no controllers listed
+		if parts[0] == "0" && parts[1] == "" {
+			return parts[2], nil
+		}
+
+		for _, controller := range strings.Split(parts[1], ",") {
+			if controller == "cpu" {
+				return parts[2], nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no cpu cgroup found for process %s", pid)
+}
+
+// cpuWeight returns cpu.weight on cgroups v2 and cpu.shares on cgroups v1
+func cpuWeight() (string, error) {
+	cgroup, err := cpuCgroup("self")
+	if err != nil {
+		return "", err
+	}
+
+	// depending on the cgroup namespace the cgroup is either visible by its
+	// full path or at the root of the mount
+	candidates := []string{
+		filepath.Join("/sys/fs/cgroup", cgroup, "cpu.weight"),
+		filepath.Join("/sys/fs/cgroup", "cpu.weight"),
+		filepath.Join("/sys/fs/cgroup/cpu", cgroup, "cpu.shares"),
+		filepath.Join("/sys/fs/cgroup/cpu", "cpu.shares"),
+		filepath.Join("/sys/fs/cgroup/cpu,cpuacct", cgroup, "cpu.shares"),
+		filepath.Join("/sys/fs/cgroup/cpu,cpuacct", "cpu.shares"),
+	}
+
+	for _, candidate := range candidates {
+		contents, err := os.ReadFile(candidate)
+		if err == nil {
+			return strings.TrimSpace(string(contents)), nil
+		}
+	}
+
+	return "", fmt.Errorf("no cpu weight found for cgroup %s", cgroup)
+}
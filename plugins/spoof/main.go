@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// spoof sends a single UDP datagram with an arbitrary source address, so that
+// specs can check that containers cannot impersonate each other.
+func main() {
+	if len(os.Args) != 5 {
+		fmt.Fprintln(os.Stderr, "usage: spoof <source-ip> <destination-ip> <destination-port> <message>")
+		os.Exit(2)
+	}
+
+	source := net.ParseIP(os.Args[1]).To4()
+	destination := net.ParseIP(os.Args[2]).To4()
+	if source == nil || destination == nil {
+		fmt.Fprintln(os.Stderr, "source and destination must be IPv4 addresses")
+		os.Exit(2)
+	}
+
+	port, err := strconv.ParseUint(os.Args[3], 10, 16)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+
+	if err := sendUDP(source, destination, uint16(port), []byte(os.Args[4])); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+// udpPacket builds an IPv4 header followed by a UDP header and the payload.
+// The kernel fills in the IP checksum and the UDP checksum is optional.
+func udpPacket(source, destination net.IP, port uint16, payload []byte) []byte {
+	const (
+		ipHeaderLen  = 20
+		udpHeaderLen = 8
+		sourcePort   = 40000
+	)
+
+	totalLen := ipHeaderLen + udpHeaderLen + len(payload)
+	packet := make([]byte, totalLen)
+
+	packet[0] = 0x45 // version 4, header length 5 words
+	putUint16(packet[2:], uint16(totalLen))
+	packet[8] = 64 // ttl
+	packet[9] = 17 // udp
+	copy(packet[12:16], source)
+	copy(packet[16:20], destination)
+
+	udp := packet[ipHeaderLen:]
+	putUint16(udp[0:], sourcePort)
+	putUint16(udp[2:], port)
+	putUint16(udp[4:], uint16(udpHeaderLen+len(payload)))
+	copy(udp[udpHeaderLen:], payload)
+
+	return packet
+}
+
+func putUint16(b []byte, v uint16) {
+	b[0] = byte(v >> 8)
+	b[1] = byte(v)
+}
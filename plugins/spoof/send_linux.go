@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+func sendUDP(source, destination net.IP, port uint16, payload []byte) error {
+	// IPPROTO_RAW implies that we supply the IP header ourselves
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_RAW)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	address := syscall.SockaddrInet4{}
+	copy(address.Addr[:], destination)
+
+	return syscall.Sendto(fd, udpPacket(source, destination, port, payload), 0, &address)
+}
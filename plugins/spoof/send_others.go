@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func sendUDP(net.IP, net.IP, uint16, []byte) error {
+	return errors.New("sending spoofed packets is only supported on linux")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+const mb = 1024 * 1024
+
+// allocated keeps the anonymous memory reachable until the workload is killed
+var allocated []byte
+
+// workload consumes known amounts of resources, so that specs can check how
+// the server accounts for them.
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "anon":
+		err = anon(os.Args[2:])
+	case "page-cache":
+		err = pageCache(os.Args[2:])
+	case "threads":
+		err = threads(os.Args[2:])
+	case "burn-user":
+		err = startBurning(burnUser, os.Args[2:])
+	case "burn-system":
+		err = startBurning(burnSystem, os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	// let the caller take measurements before we release the resources
+	fmt.Println("ready")
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	<-signals
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: workload anon <megabytes> | page-cache <path> <megabytes> | threads <count> | burn-user [cpu] | burn-system [cpu]")
+	os.Exit(2)
+}
+
+// anon allocates and touches the given amount of anonymous memory
+func anon(args []string) error {
+	if len(args) != 1 {
+		usage()
+	}
+
+	size, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+
+	allocated = make([]byte, size*mb)
+	for i := 0; i < len(allocated); i += os.Getpagesize() {
+		allocated[i] = 1
+	}
+
+	return nil
+}
+
+// pageCache writes the given amount of data to a file and reads it back, so
+// that it ends up in the page cache
+func pageCache(args []string) error {
+	if len(args) != 2 {
+		usage()
+	}
+
+	size, err := strconv.Atoi(args[1])
+	if err != nil {
+		return err
+	}
+
+	chunk := make([]byte, mb)
+	for i := range chunk {
+		chunk[i] = 1
+	}
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for i := 0; i < size; i++ {
+		if _, err := file.Write(chunk); err != nil {
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	if _, err := file.Seek(0, 0); err != nil {
+		return err
+	}
+	for i := 0; i < size; i++ {
+		if _, err := file.Read(chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// threads starts the given number of OS threads, which all count as pids
+func threads(args []string) error {
+	if len(args) != 1 {
+		usage()
+	}
+
+	count, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+
+	var started sync.WaitGroup
+	for i := 0; i < count; i++ {
+		started.Add(1)
+		go func() {
+			// a goroutine locked to its thread forces the runtime to start a new
+			// thread for the next one
+			runtime.LockOSThread()
+			started.Done()
+			<-make(chan struct{})
+		}()
+	}
+	started.Wait()
+
+	return nil
+}
+
+// startBurning runs burn in the background, optionally pinned to a single cpu
+func startBurning(burn func(), args []string) error {
+	if len(args) > 1 {
+		usage()
+	}
+
+	cpu := -1
+	if len(args) == 1 {
+		var err error
+		if cpu, err = strconv.Atoi(args[0]); err != nil {
+			return err
+		}
+	}
+
+	pinned := make(chan error)
+	go func() {
+		if cpu >= 0 {
+			// affinity is per thread, so keep the burner on the pinned one
+			runtime.LockOSThread()
+			if err := pinToCPU(cpu); err != nil {
+				pinned <- err
+				return
+			}
+		}
+		pinned <- nil
+		burn()
+	}()
+
+	return <-pinned
+}
+
+// burnUser keeps one CPU busy in user space
+func burnUser() {
+	x := 0
+	for {
+		x++
+	}
+}
+
+// burnSystem keeps one CPU busy in the kernel by having it zero buffers
+func burnSystem() {
+	zero, err := os.Open("/dev/zero")
+	if err != nil {
+		panic(err)
+	}
+
+	buf := make([]byte, mb)
+	for {
+		if _, err := zero.Read(buf); err != nil {
+			panic(err)
+		}
+	}
+}
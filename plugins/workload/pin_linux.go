@@ -0,0 +1,9 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+func pinToCPU(cpu int) error {
+	var set unix.CPUSet
+	set.Set(cpu)
+	return unix.SchedSetaffinity(0, &set)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func pinToCPU(int) error {
+	return errors.New("pinning to a cpu is only supported on linux")
+}
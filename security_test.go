@@ -217,6 +217,68 @@ var _ = Describe("Security", func() {
 			})
 			Expect(stdout).To(gbytes.Say("processes\\W+(-u)\\W+4567"))
 		})
+
+		rlimitRunners := []struct {
+			description string
+			processSpec func() garden.ProcessSpec
+		}{
+			{
+				description: "for root",
+				processSpec: func() garden.ProcessSpec {
+					return garden.ProcessSpec{User: "root"}
+				},
+			},
+			{
+				description: "for a non-root user",
+				processSpec: func() garden.ProcessSpec {
+					createUser(container, "alice")
+					return garden.ProcessSpec{User: "alice"}
+				},
+			},
+			{
+				description: "for a pea",
+				processSpec: func() garden.ProcessSpec {
+					skipIfShed()
+					return garden.ProcessSpec{User: "1000:1000", Image: peaImage}
+				},
+			},
+		}
+
+		for _, runner := range rlimitRunners {
+			Context(runner.description, func() {
+				DescribeTable("sets the soft and hard limits",
+					func(limits garden.ResourceLimits, expected map[string]uint64) {
+						spec := runner.processSpec()
+						spec.Path = "cat"
+						spec.Args = []string{"/proc/self/limits"}
+						spec.Limits = limits
+
+						stdout := runForStdout(container, spec)
+						for name, value := range expected {
+							Expect(string(stdout.Contents())).To(MatchRegexp(`(?m)^%s\s+%d\s+%d\b`, regexp.QuoteMeta(name), value, value))
+						}
+					},
+					rlimitEntries(),
+				)
+
+				DescribeTable("enforces the limits",
+					func(limits garden.ResourceLimits, script string, expectedExitCode int, expectedStderr string) {
+						spec := runner.processSpec()
+						spec.Path = "sh"
+						spec.Args = []string{"-c", script}
+						spec.Limits = limits
+
+						exitCode, _, stderr := runProcess(container, spec)
+						Expect(exitCode).To(Equal(expectedExitCode))
+						Expect(string(stderr.Contents())).To(ContainSubstring(expectedStderr))
+					},
+					// the signals kill the child silently, so the shell names them on stderr
+					Entry("Fsize raises SIGXFSZ", garden.ResourceLimits{Fsize: rlimit(mb)}, "dd if=/dev/zero of=/tmp/rlimit-fsize bs=1K count=2048; status=$?; kill -l $((status - 128)) >&2; exit $status", 128+25, "XFSZ"),
+					Entry("Cpu raises SIGXCPU", garden.ResourceLimits{Cpu: rlimit(1)}, "sh -c 'while true; do :; done'; status=$?; kill -l $((status - 128)) >&2; exit $status", 128+24, "XCPU"),
+					Entry("Nofile fails with EMFILE", garden.ResourceLimits{Nofile: rlimit(5)}, "exec 3</dev/null 4</dev/null; cat /dev/null", 1, "Too many open files"),
+				)
+			})
+		}
 	})
 
 	Describe("Users and groups", func() {
@@ -652,3 +714,50 @@ var _ = Describe("Security", func() {
 		})
 	})
 })
+
+func rlimit(value uint64) *uint64 {
+	return &value
+}
+
+// rlimitEntries sets each rlimit on its own and then all of them at once,
+// keyed by their name in /proc/self/limits
+func rlimitEntries() []TableEntry {
+	rlimits := []struct {
+		field string
+		name  string
+		value uint64
+		set   func(*garden.ResourceLimits, *uint64)
+	}{
+		{"As", "Max address space", gb, func(l *garden.ResourceLimits, v *uint64) { l.As = v }},
+		{"Core", "Max core file size", 0, func(l *garden.ResourceLimits, v *uint64) { l.Core = v }},
+		{"Cpu", "Max cpu time", 100, func(l *garden.ResourceLimits, v *uint64) { l.Cpu = v }},
+		{"Data", "Max data size", gb, func(l *garden.ResourceLimits, v *uint64) { l.Data = v }},
+		{"Fsize", "Max file size", 100 * mb, func(l *garden.ResourceLimits, v *uint64) { l.Fsize = v }},
+		{"Locks", "Max file locks", 100, func(l *garden.ResourceLimits, v *uint64) { l.Locks = v }},
+		{"Memlock", "Max locked memory", 64 * kb, func(l *garden.ResourceLimits, v *uint64) { l.Memlock = v }},
+		{"Msgqueue", "Max msgqueue size", 819200, func(l *garden.ResourceLimits, v *uint64) { l.Msgqueue = v }},
+		{"Nice", "Max nice priority", 10, func(l *garden.ResourceLimits, v *uint64) { l.Nice = v }},
+		{"Nofile", "Max open files", 1024, func(l *garden.ResourceLimits, v *uint64) { l.Nofile = v }},
+		{"Nproc", "Max processes", 4567, func(l *garden.ResourceLimits, v *uint64) { l.Nproc = v }},
+		{"Rss", "Max resident set", gb, func(l *garden.ResourceLimits, v *uint64) { l.Rss = v }},
+		{"Rtprio", "Max realtime priority", 5, func(l *garden.ResourceLimits, v *uint64) { l.Rtprio = v }},
+		{"Sigpending", "Max pending signals", 1000, func(l *garden.ResourceLimits, v *uint64) { l.Sigpending = v }},
+		{"Stack", "Max stack size", 8 * mb, func(l *garden.ResourceLimits, v *uint64) { l.Stack = v }},
+	}
+
+	var (
+		entries     []TableEntry
+		allLimits   garden.ResourceLimits
+		allExpected = map[string]uint64{}
+	)
+	for _, r := range rlimits {
+		var limits garden.ResourceLimits
+		r.set(&limits, rlimit(r.value))
+		entries = append(entries, Entry(r.field, limits, map[string]uint64{r.name: r.value}))
+
+		r.set(&allLimits, rlimit(r.value))
+		allExpected[r.name] = r.value
+	}
+
+	return append(entries, Entry("all of them at once", allLimits, allExpected))
+}